@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+)
+
+// configCommand runs "bookings config print", which loads the configuration the way the server
+// does and prints it with secrets redacted, followed by any problems found. It returns the exit code
+func configCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: bookings config print [-config file] [flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	var c config.AppConfig
+	err := config.Load(&c, fs, args[1:], os.LookupEnv)
+
+	var invalid *config.ValidationError
+	if err != nil && !errors.As(err, &invalid) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := config.Print(os.Stdout, &c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if invalid != nil {
+		fmt.Fprintln(os.Stderr, invalid)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/driver"
+	"github.com/DungBuiTien1999/bookings/internal/health"
+	"github.com/DungBuiTien1999/bookings/internal/mailer"
+	"github.com/DungBuiTien1999/bookings/internal/render"
+)
+
+// readyTimeout bounds each readiness check
+const readyTimeout = 2 * time.Second
+
+// healthChecker answers /healthz and /readyz
+var healthChecker = health.New(readyTimeout)
+
+// addHealthChecks has readiness depend on the database and the template cache. When mail goes
+// through a relay, the SMTP relay is reported too but doesn't fail readiness: mail waits in the
+// outbox until it is back
+func addHealthChecks(db *driver.DB, sender mailer.Mailer) {
+	healthChecker.Add("database", db.SQL.PingContext, true)
+	healthChecker.Add("templates", func(ctx context.Context) error {
+		if len(render.Templates()) == 0 {
+			return errors.New("template cache is empty")
+		}
+		return nil
+	}, true)
+	if relay, ok := sender.(*mailer.SMTPMailer); ok {
+		healthChecker.Add("smtp", relay.Ping, false)
+	}
+}
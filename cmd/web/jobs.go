@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/jobs"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
+)
+
+// jobDrainTimeout is how long shutdown waits for running jobs before cancelling them
+const jobDrainTimeout = 30 * time.Second
+
+var jobRunner *jobs.Runner
+
+// startJobs registers the background jobs and starts running them
+func startJobs(db repository.DatabaseRepo) error {
+	host, _ := os.Hostname()
+	jobRunner = jobs.New(db, fmt.Sprintf("%s-%d", host, os.Getpid()), app.Logger)
+
+	err := jobRunner.Every("trash-purge", "@hourly", purgeTrash(db))
+	if err != nil {
+		return err
+	}
+	err = jobRunner.Every("scheduled-mail", "*/15 * * * *", sendReminders(db))
+	if err != nil {
+		return err
+	}
+
+	return jobRunner.Start()
+}
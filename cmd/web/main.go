@@ -1,52 +1,159 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/gob"
 	"flag"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/DungBuiTien1999/bookings"
+	"github.com/DungBuiTien1999/bookings/internal/assets"
 	"github.com/DungBuiTien1999/bookings/internal/config"
 	"github.com/DungBuiTien1999/bookings/internal/driver"
 	"github.com/DungBuiTien1999/bookings/internal/handlers"
 	"github.com/DungBuiTien1999/bookings/internal/helpers"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/mailer"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/notify"
+	"github.com/DungBuiTien1999/bookings/internal/ratelimit"
 	"github.com/DungBuiTien1999/bookings/internal/render"
+	"github.com/DungBuiTien1999/bookings/internal/sessionstore"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+	"github.com/DungBuiTien1999/bookings/internal/webhooks"
 	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
 )
 
-const portNumber = ":9090"
-
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+
+// notifier tells staff about the events published by the handlers
+var notifier *notify.Notifier
+
+// rateLimits keeps the token buckets of the rate limits
+var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+
+// staticAssets serves the fingerprinted and compressed files under /static
+var staticAssets *assets.Pipeline
+
+// stopTracing exports the spans still buffered, it is called last at shutdown
+var stopTracing func(context.Context) error
+
+// webhookDispatcher posts the events published by the handlers to the webhooks configured by admins
+var webhookDispatcher *webhooks.Dispatcher
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			os.Exit(configCommand(os.Args[2:]))
+		case "migrations":
+			os.Exit(migrationsCommand(os.Args[2:]))
+		}
+	}
+
+	err := start()
+	if err != nil {
+		// the default logger is the application's once the configuration is loaded
+		slog.Error("can't run application", "error", err)
+		os.Exit(1)
+	}
+}
+
+// start runs the application until it is told to stop. Its deferred stops have all run by the time
+// it returns, so main can exit with an error without cutting them short
+func start() error {
 	db, err := run()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.SQL.Close()
-	defer close(app.MailChan)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := stopTracing(ctx); err != nil {
+			app.Logger.Error("can't export spans", "error", err)
+		}
+	}()
 
-	fmt.Println("Starting mail listener...")
-	listenForMail()
+	app.Logger.Info("starting mail dispatcher", "workers", app.MailWorkers)
+	startMailDispatcher(handlers.Repo.DB)
+	defer mailDispatcher.Stop()
 
-	fmt.Println(fmt.Printf("Starting application on port %s", portNumber))
+	if app.Features.Webhooks {
+		app.Logger.Info("starting webhook dispatcher")
+		webhookDispatcher.Start()
+		defer webhookDispatcher.Stop()
+	}
+
+	if app.Features.Jobs {
+		app.Logger.Info("starting background jobs")
+		err = startJobs(handlers.Repo.DB)
+		if err != nil {
+			return fmt.Errorf("can't start background jobs: %w", err)
+		}
+		defer jobRunner.Stop(jobDrainTimeout)
+	}
+
+	if app.TemplateReload {
+		dir := filepath.Join(app.FilesDir, "templates")
+		app.Logger.Info("watching templates", "dir", dir)
+		stopWatching, err := watchTemplates(dir)
+		if err != nil {
+			return fmt.Errorf("can't watch templates in %s: %w", dir, err)
+		}
+		defer stopWatching()
+	}
+
+	if cleaner, ok := session.Store.(interface{ StopCleanup() }); ok {
+		defer cleaner.StopCleanup()
+	}
+
+	l, err := net.Listen("tcp", app.Addr)
+	if err != nil {
+		return fmt.Errorf("can't listen on %s: %w", app.Addr, err)
+	}
+	app.Logger.Info("starting application", "addr", app.Addr)
+
+	if ms := newMetricsServer(); ms != nil {
+		app.Logger.Info("serving metrics", "addr", ms.Addr)
+		go func() {
+			err := ms.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				app.Logger.Error("can't serve metrics", "addr", ms.Addr, "error", err)
+			}
+		}()
+		defer ms.Close()
+	}
 
 	srv := &http.Server{
-		Addr:    portNumber,
 		Handler: routes(&app),
 	}
+	if app.TemplateReload {
+		// polls waiting for a template change would hold up shutdown
+		srv.RegisterOnShutdown(render.StopReload)
+	}
 
-	err = srv.ListenAndServe()
+	// returning runs the deferred stops in reverse order: jobs, webhooks and mail finish what they
+	// hold, then the database pool is closed. Undelivered mail and webhooks stay in their tables
+	err = serve(srv, l, app.ShutdownTimeout, syscall.SIGINT, syscall.SIGTERM)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("shutdown: %w", err)
 	}
+	app.Logger.Info("stopped accepting requests, shutting down")
+	return nil
 }
 
 func run() (*driver.DB, error) {
@@ -57,66 +164,121 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(map[string]int{})
 
-	// read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "skip-verify", "Database ssl settings (skip-verify, preferred)")
-
-	flag.Parse()
-	if *dbName == "" || *dbUser == "" || *dbPass == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+	err := config.Load(&app, flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return nil, err
 	}
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	logger, err := logging.New(os.Stdout, app.LogFormat, strings.ToLower(app.LogLevel))
+	if err != nil {
+		return nil, err
+	}
+	app.Logger = logger
+	slog.SetDefault(logger)
 
-	// change this to true when in production
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
+	stopTracing, err = tracing.Setup(app.Tracing.Exporter, app.Tracing.Endpoint, os.Stdout)
+	if err != nil {
+		return nil, fmt.Errorf("can't set up tracing: %w", err)
+	}
+
+	sender, err := mailer.New(&app)
+	if err != nil {
+		return nil, err
+	}
+	mailSender = sender
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	// connect to database
+	app.Logger.Info("connecting to database", "host", app.Database.Host, "database", app.Database.Name)
+	db, err := driver.ConnectSQL(app.Database.DSN(), driver.Retry{
+		Attempts: app.Database.ConnectAttempts,
+		Wait:     time.Second,
+		MaxWait:  30 * time.Second,
+		Logger:   app.Logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to database: %w", err)
+	}
+	app.Logger.Info("connected to database")
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	store, err := newSessionStore(app.SessionStore, db.SQL, app.SessionCleanup, app.Logger)
+	if err != nil {
+		return nil, err
+	}
 
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Store = countSessions(store)
+	session.Lifetime = app.SessionLifetime
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
 
-	// connect to database
-	log.Println("Connecting to database...")
-	// modify user:password of database yourself (here is root:root) root:root@/golangbookings?parseTime=true
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&tls=%s", *dbUser, *dbPass, *dbHost, *dbPort, *dbName, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
+	// templates and assets are embedded in the binary, or read from disk with -filesdir
+	files := bookings.Files(app.FilesDir)
+	templates, _ := fs.Sub(files, "templates")
+	emailTemplates, _ := fs.Sub(files, "email-templates")
+	staticFiles, _ := fs.Sub(files, "static")
+	render.SetTemplates(templates)
+	mailrender.SetTemplates(emailTemplates)
+
+	// files read from disk are being edited, so they are hashed again when they change
+	if app.FilesDir != "" {
+		staticAssets, err = assets.NewLive(staticFiles, "/static/")
+	} else {
+		staticAssets, err = assets.New(staticFiles, "/static/")
+	}
 	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+		return nil, fmt.Errorf("can't load static files: %w", err)
 	}
-	log.Println("Connected to database...")
+	render.SetAssets(staticAssets)
 
-	tc, err := render.CreateTemplateCache()
+	tc, err := render.CreateTemplateCache(templates)
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("can't create template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
+	if app.TemplateReload {
+		render.EnableReload()
+	}
+	addHealthChecks(db, sender)
 
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
 
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
+	mailrender.NewMailrender(&app, repo.DB)
+
+	webhookDispatcher = webhooks.New(repo.DB, app.Logger)
+	if app.Features.Webhooks {
+		repo.Events.Subscribe(webhookDispatcher.Enqueue)
+	}
+
+	notifier = notify.New(repo.DB, app.Logger)
+	notifier.Owner = app.OwnerEmail
+	// staff webhooks are posted by the webhook dispatcher, so they are off along with it
+	if app.Features.Webhooks {
+		notifier.Webhooks = webhookDispatcher
+	}
+	if app.Features.Notifications {
+		repo.Events.Subscribe(notifier.Notify)
+	}
 
 	return db, nil
 }
+
+// newSessionStore returns the session store for kind. The mysql store keeps sessions in the
+// sessions table so they survive restarts and can be shared by several instances, and removes
+// expired rows every cleanup interval
+func newSessionStore(kind string, db *sql.DB, cleanup time.Duration, logger *slog.Logger) (scs.Store, error) {
+	switch kind {
+	case "memory":
+		return memstore.New(), nil
+	case "mysql":
+		return sessionstore.NewMySQL(db, cleanup, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", kind)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"log/slog"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
 	_, err := run()
@@ -8,3 +11,18 @@ func TestRun(t *testing.T) {
 		t.Error("failed run()")
 	}
 }
+
+func TestNewSessionStore(t *testing.T) {
+	store, err := newSessionStore("memory", nil, 0, slog.Default())
+	if err != nil {
+		t.Error(err)
+	}
+	if store == nil {
+		t.Error("expected memory store, got nil")
+	}
+
+	_, err = newSessionStore("redis", nil, 0, slog.Default())
+	if err == nil {
+		t.Error("expected error for unknown session store")
+	}
+}
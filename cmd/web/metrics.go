@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
+	"github.com/alexedwards/scs/v2"
+)
+
+// metricsHandler serves /metrics, asking for basic auth when a username is configured
+func metricsHandler() http.Handler {
+	h := metrics.Handler()
+	if app.Metrics.Username == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(app.Metrics.Username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(app.Metrics.Password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newMetricsServer returns the server for /metrics on its own address, or nil when it is served
+// with the site or turned off
+func newMetricsServer() *http.Server {
+	if !app.Metrics.Enabled || app.Metrics.Addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	return &http.Server{
+		Addr:    app.Metrics.Addr,
+		Handler: mux,
+	}
+}
+
+// countSessions feeds the active sessions gauge from store and returns the store to use. Stores
+// that can count their sessions are asked, the others are wrapped to keep track of them
+func countSessions(store scs.Store) scs.Store {
+	if counter, ok := store.(interface {
+		Count() (int, error)
+	}); ok {
+		metrics.CountSessions(counter.Count)
+		return store
+	}
+
+	tracked := &trackedStore{Store: store, expiry: make(map[string]time.Time)}
+	metrics.CountSessions(tracked.count)
+	return tracked
+}
+
+// trackedStore remembers when the sessions committed to a store expire
+type trackedStore struct {
+	scs.Store
+
+	mu         sync.Mutex
+	expiry     map[string]time.Time
+	lastPruned time.Time
+}
+
+func (s *trackedStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := s.Store.Commit(token, b, expiry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiry[token] = expiry
+	if time.Since(s.lastPruned) > time.Minute {
+		s.prune()
+	}
+	return nil
+}
+
+func (s *trackedStore) Delete(token string) error {
+	err := s.Store.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.expiry, token)
+	return nil
+}
+
+// StopCleanup stops the cleanup of the wrapped store, if it has one
+func (s *trackedStore) StopCleanup() {
+	if cleaner, ok := s.Store.(interface{ StopCleanup() }); ok {
+		cleaner.StopCleanup()
+	}
+}
+
+func (s *trackedStore) count() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	return len(s.expiry), nil
+}
+
+// prune forgets expired sessions, s.mu must be held
+func (s *trackedStore) prune() {
+	now := time.Now()
+	for token, expiry := range s.expiry {
+		if !now.Before(expiry) {
+			delete(s.expiry, token)
+		}
+	}
+	s.lastPruned = now
+}
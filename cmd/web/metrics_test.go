@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/go-chi/chi/v5"
+)
+
+func scrape(t *testing.T, h http.Handler, user, pass string) (int, string) {
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	body, _ := ioutil.ReadAll(rr.Body)
+	return rr.Code, string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	defer func(m config.MetricsConfig) { app.Metrics = m }(app.Metrics)
+
+	app.Metrics = config.MetricsConfig{Enabled: true, Username: "prometheus", Password: "s3cret"}
+	h := metricsHandler()
+
+	if code, _ := scrape(t, h, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d", code)
+	}
+	if code, _ := scrape(t, h, "prometheus", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with the wrong password, got %d", code)
+	}
+	if code, body := scrape(t, h, "prometheus", "s3cret"); code != http.StatusOK || !strings.Contains(body, "bookings_sessions_active") {
+		t.Errorf("expected the metrics, got %d", code)
+	}
+
+	app.Metrics = config.MetricsConfig{Enabled: true, Addr: "127.0.0.1:9091"}
+	if code, _ := scrape(t, metricsHandler(), "", ""); code != http.StatusOK {
+		t.Errorf("expected no auth on the metrics address without a username, got %d", code)
+	}
+	if srv := newMetricsServer(); srv == nil || srv.Addr != "127.0.0.1:9091" {
+		t.Error("expected a metrics server on its own address")
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(Instrument)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, url := range []string{"/rooms/1", "/rooms/2", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+
+	app.Metrics.Username = ""
+	_, body := scrape(t, metricsHandler(), "", "")
+	for _, want := range []string{
+		`bookings_http_requests_total{method="GET",route="/rooms/{id}",status="418"} 2`,
+		`bookings_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s", want)
+		}
+	}
+}
+
+// countingStore is a session store counting its sessions itself
+type countingStore struct {
+	*memstore.MemStore
+}
+
+func (s countingStore) Count() (int, error) {
+	return 42, nil
+}
+
+func TestCountSessionsWithCounter(t *testing.T) {
+	store := countSessions(countingStore{memstore.New()})
+	if _, ok := store.(countingStore); !ok {
+		t.Fatalf("expected a store that counts its sessions to be used as is, got %T", store)
+	}
+
+	_, body := scrape(t, metricsHandler(), "", "")
+	if !strings.Contains(body, "bookings_sessions_active 42") {
+		t.Errorf("expected the count of the store")
+	}
+}
+
+func TestCountSessions(t *testing.T) {
+	store := countSessions(memstore.New())
+	defer store.(*trackedStore).StopCleanup()
+
+	_ = store.Commit("a", []byte("x"), time.Now().Add(time.Hour))
+	_ = store.Commit("b", []byte("x"), time.Now().Add(time.Hour))
+	_ = store.Commit("c", []byte("x"), time.Now().Add(-time.Second))
+	_ = store.Delete("b")
+
+	_, body := scrape(t, metricsHandler(), "", "")
+	if !strings.Contains(body, "bookings_sessions_active 1") {
+		t.Errorf("expected one active session")
+	}
+}
@@ -1,15 +1,26 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/DungBuiTien1999/bookings/internal/csp"
 	"github.com/DungBuiTien1999/bookings/internal/helpers"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
+	"github.com/DungBuiTien1999/bookings/internal/ratelimit"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 )
 
 // NoSurf adds CSRF protection to all POST request
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// browsers post violation reports without a token
+	csrfHandler.ExemptPath(csp.ReportPath)
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -21,9 +32,58 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrfHandler
 }
 
-// SessionLoad loads and saves the session on every request
+// SessionLoad loads and saves the session on every request, adding the logged in user to the request log line
 func SessionLoad(next http.Handler) http.Handler {
-	return session.LoadAndSave(next)
+	return session.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID := session.GetInt(r.Context(), "user_id"); userID > 0 {
+			logging.Annotate(r.Context(), slog.Int("user_id", userID))
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// probes are the orchestrator's health checks, logged at debug level as they come every few seconds
+var probes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// RequestLogger gives every request an ID, sent back in the X-Request-ID header, and a logger
+// carrying it, and logs the request once it completes with its status, duration and the fields
+// handlers added
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		ctx := logging.WithRequest(r.Context(), app.Logger.With("request_id", id))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("duration", time.Since(start)),
+		}
+		attrs = append(attrs, logging.Annotations(ctx)...)
+
+		level := slog.LevelInfo
+		if probes[r.URL.Path] {
+			level = slog.LevelDebug
+		}
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(ctx).LogAttrs(ctx, level, "request", attrs...)
+	})
 }
 
 // Auth protects roots which needs authenticated
@@ -37,3 +97,116 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RateLimit limits each client to limit requests to the routes of group, as parsed by
+// ratelimit.ParseLimit, answering the requests over it with exceeded
+func RateLimit(group, limit string, exceeded http.HandlerFunc) func(http.Handler) http.Handler {
+	l, err := ratelimit.ParseLimit(limit)
+	if err != nil {
+		// the configuration is checked when it is loaded
+		panic(err)
+	}
+	return ratelimit.Middleware(rateLimits, group, l, rateLimitKey, exceeded)
+}
+
+// rateLimitKey tells clients apart by IP address or, with ratelimit.key set to session, by their
+// session cookie. The cookie only counts once its session holds data, as a made up cookie loads an
+// empty session, so a client can't get a fresh bucket with every request
+func rateLimitKey(r *http.Request) string {
+	if app.RateLimit.Key == "session" && len(session.Keys(r.Context())) > 0 {
+		if c, err := r.Cookie(session.Cookie.Name); err == nil {
+			return "session:" + c.Value
+		}
+	}
+	return "ip:" + helpers.ClientIP(r)
+}
+
+// Trace gives every request a span, continuing the trace of the caller when it sent a traceparent
+// header, so the spans of the repository, templates and the background work it queues join it. The
+// trace id is added to the request log line
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.StartRequest(r)
+		if id := span.SpanContext().TraceID(); id.IsValid() {
+			logging.Annotate(ctx, slog.String("trace_id", id.String()))
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		tracing.EndRequest(span, r.Method, routePattern(r), status)
+	})
+}
+
+// Instrument records the count and latency of requests by route pattern
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveRequest(r.Method, routePattern(r), status, time.Since(start))
+	})
+}
+
+// routePattern returns the pattern of the route r matched, once it has been served, or "unmatched"
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return "unmatched"
+}
+
+// SecurityHeaders sets the security headers of every response: a Content-Security-Policy with a
+// fresh nonce for the inline scripts, put in the request context for the templates, sent
+// report-only when app.CSPReportOnly is set, along with nosniff, the referrer policy and, in
+// production, HSTS. X-Frame-Options backs frame-ancestors, which a report-only policy does not
+// enforce
+func SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := csp.NewNonce()
+
+		h := w.Header()
+		policyHeader := "Content-Security-Policy"
+		if app.CSPReportOnly {
+			policyHeader = "Content-Security-Policy-Report-Only"
+		}
+		h.Set(policyHeader, csp.Policy(nonce))
+		h.Set("Reporting-Endpoints", csp.ReportGroup+`="`+csp.ReportPath+`"`)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.Set("X-Frame-Options", "DENY")
+		if app.InProduction {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+
+		next.ServeHTTP(&policyWriter{ResponseWriter: w, header: policyHeader}, r.WithContext(csp.WithNonce(r.Context(), nonce)))
+	})
+}
+
+// policyWriter drops the policy from 304 responses. The browser keeps the headers a 304 does not
+// send, so the cached page goes on running with the policy, and nonce, it was sent with
+type policyWriter struct {
+	http.ResponseWriter
+	header string
+}
+
+func (w *policyWriter) WriteHeader(code int) {
+	if code == http.StatusNotModified {
+		w.Header().Del(w.header)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the writer underneath, for http.ResponseController
+func (w *policyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/DungBuiTien1999/bookings/internal/csp"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -31,3 +40,118 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	var out bytes.Buffer
+	app.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+
+	h := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.Annotate(r.Context(), slog.Int("reservation_id", 7))
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("GET", "/make-reservation", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	id := rr.Header().Get(logging.RequestIDHeader)
+	if !logging.ValidRequestID(id) {
+		t.Fatalf("expected a request id header, got %q", id)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json line, got %q", out.String())
+	}
+	if line["request_id"] != id || line["status"] != float64(http.StatusCreated) || line["reservation_id"] != float64(7) || line["path"] != "/make-reservation" {
+		t.Errorf("unexpected request line %v", line)
+	}
+	if _, ok := line["duration"]; !ok {
+		t.Errorf("expected the duration in %v", line)
+	}
+
+	// an id set by a proxy is kept, one that is not safe to log is replaced
+	for sent, kept := range map[string]bool{"from-the-proxy-1": true, "bad id\n": false} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(logging.RequestIDHeader, sent)
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if (rr.Header().Get(logging.RequestIDHeader) == sent) != kept {
+			t.Errorf("for %q expected kept to be %v, got %q", sent, kept, rr.Header().Get(logging.RequestIDHeader))
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var out bytes.Buffer
+	app.Logger = slog.New(slog.NewJSONHandler(&out, nil))
+	_, _ = tracing.Setup("none", "", nil)
+
+	h := RequestLogger(Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(tracing.Inject(r.Context()), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+			t.Errorf("expected the handler to run in the trace of the caller, got %q", tracing.Inject(r.Context()))
+		}
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json line, got %q", out.String())
+	}
+	if line["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected the trace id in %v", line)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	defer func(production, reportOnly bool) {
+		app.InProduction, app.CSPReportOnly = production, reportOnly
+	}(app.InProduction, app.CSPReportOnly)
+	app.InProduction, app.CSPReportOnly = true, false
+
+	var nonce string
+	h := SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = csp.Nonce(r.Context())
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if nonce == "" || !strings.Contains(rr.Header().Get("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+		t.Errorf("expected the policy to allow the nonce of the request, got %q", rr.Header().Get("Content-Security-Policy"))
+	}
+	for header, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"X-Frame-Options":           "DENY",
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+	} {
+		if rr.Header().Get(header) != want {
+			t.Errorf("expected %s %q, got %q", header, want, rr.Header().Get(header))
+		}
+	}
+
+	// a revalidated page keeps the policy it was cached with
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `W/"abc"`)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("expected a 304 without a policy, got %d %q", rr.Code, rr.Header().Get("Content-Security-Policy"))
+	}
+
+	app.InProduction, app.CSPReportOnly = false, true
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Header().Get("Content-Security-Policy") != "" || rr.Header().Get("Content-Security-Policy-Report-Only") == "" {
+		t.Errorf("expected a report-only policy, got %v", rr.Header())
+	}
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("expected no HSTS outside production")
+	}
+}
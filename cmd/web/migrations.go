@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/DungBuiTien1999/bookings"
+)
+
+// migrationsCommand runs "bookings migrations <dir>", which writes the migrations embedded in the
+// binary to dir so soda can run them where only the binary was deployed. It returns the exit code
+func migrationsCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: bookings migrations <dir>")
+		return 2
+	}
+
+	migrations, err := fs.Sub(bookings.Files(""), "migrations")
+	if err == nil {
+		err = extract(migrations, args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// extract copies the files of fsys into dir, creating it when needed
+func extract(fsys fs.FS, dir string) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, b, 0644)
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationsCommand(t *testing.T) {
+	if code := migrationsCommand(nil); code != 2 {
+		t.Errorf("expected the usage exit code without a directory, got %d", code)
+	}
+
+	dir := filepath.Join(t.TempDir(), "migrations")
+	if code := migrationsCommand([]string{dir}); code != 0 {
+		t.Fatalf("expected success, got exit code %d", code)
+	}
+
+	for _, name := range []string{"schema.sql", "20211019081530_create_jobs_table.up.fizz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be written: %s", name, err)
+		}
+	}
+}
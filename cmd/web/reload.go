@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/render"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay lets the burst of events of an editor saving a file settle before reloading
+const reloadDelay = 100 * time.Millisecond
+
+// watchTemplates reloads the templates of dir as they change, until the returned function is called
+func watchTemplates(dir string) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		changed := make(map[string]bool)
+		var settle <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// editors save through temporary files and renames, only the templates matter
+				name := filepath.Base(event.Name)
+				if strings.HasSuffix(name, ".tmpl") {
+					changed[name] = true
+					settle = time.After(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				app.Logger.Error("can't watch templates", "dir", dir, "error", err)
+			case <-settle:
+				for name := range changed {
+					if err := render.Reload(name); err != nil {
+						app.Logger.Error("can't reload template", "template", name, "error", err)
+						continue
+					}
+					app.Logger.Info("reloaded template", "template", name)
+				}
+				changed = make(map[string]bool)
+				settle = nil
+			}
+		}
+	}()
+
+	return func() {
+		watcher.Close()
+		<-done
+	}, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/render"
+)
+
+func TestWatchTemplates(t *testing.T) {
+	dir := t.TempDir()
+	app.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	render.NewRenderer(&app)
+	render.SetTemplates(os.DirFS(dir))
+	defer func() { app.TemplateCache = nil }()
+
+	stop, err := watchTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	if err := os.WriteFile(filepath.Join(dir, "welcome.page.tmpl"), []byte("<p>Welcome</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for render.Templates()["welcome.page.tmpl"] == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new page to be loaded into the template cache")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
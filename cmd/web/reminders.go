@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/jobs"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/reminders"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
+)
+
+// sendReminders returns the job queueing the scheduled guest mail that is due
+func sendReminders(db repository.DatabaseRepo) jobs.Handler {
+	schedules := []reminders.Schedule{
+		{Name: mailrender.Reminder, Days: app.ReminderDays},
+		{Name: mailrender.CheckInInstructions, Days: app.CheckInInstructionsDays},
+		{Name: mailrender.FollowUp, Days: app.FollowUpDays, AfterStay: true},
+	}
+
+	return func(ctx context.Context, j models.Job) error {
+		scheduler := reminders.New(db.WithContext(ctx), schedules, app.Logger)
+		_, err := scheduler.Run(time.Now())
+		return err
+	}
+}
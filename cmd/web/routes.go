@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/csp"
 	"github.com/DungBuiTien1999/bookings/internal/handlers"
+	"github.com/DungBuiTien1999/bookings/internal/render"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -12,33 +14,58 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewMux()
 
+	mux.Use(RequestLogger)
+	mux.Use(Trace)
+	mux.Use(Instrument)
+	mux.Use(SecurityHeaders)
 	mux.Use(middleware.Recoverer)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
+	mux.NotFound(handlers.Repo.NotFound)
+	mux.MethodNotAllowed(handlers.Repo.MethodNotAllowed)
+
+	mux.Post(csp.ReportPath, handlers.Repo.CSPReport)
+
+	mux.Get("/healthz", healthChecker.Live)
+	mux.Get("/readyz", healthChecker.Ready)
+
+	if app.Metrics.Enabled && app.Metrics.Addr == "" {
+		mux.Handle("/metrics", metricsHandler())
+	}
+
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
 	mux.Get("/generals-quarters", handlers.Repo.Generals)
 	mux.Get("/majors-suite", handlers.Repo.Majors)
 
+	// the posts run the availability query, book rooms and check passwords, so they are rate limited
+	searchLimit := RateLimit("search", app.RateLimit.Search, handlers.Repo.TooManyRequests)
+	searchJSONLimit := RateLimit("search", app.RateLimit.Search, handlers.Repo.TooManyRequestsJSON)
+	reservationLimit := RateLimit("reservation", app.RateLimit.Reservation, handlers.Repo.TooManyRequests)
+	loginLimit := RateLimit("login", app.RateLimit.Login, handlers.Repo.TooManyRequests)
+
 	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.PostAvailabilityJSON)
+	mux.With(searchLimit).Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.With(searchJSONLimit).Post("/search-availability-json", handlers.Repo.PostAvailabilityJSON)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
 	mux.Get("/contact", handlers.Repo.Contact)
+	mux.Post("/contact", handlers.Repo.PostContact)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.With(loginLimit).Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.With(reservationLimit).Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+	mux.Handle("/static/*", http.StripPrefix("/static", staticAssets))
+	if app.TemplateReload {
+		mux.Get(render.ReloadPath, render.ReloadHandler)
+	}
 
 	mux.Route("/admin", func(mux chi.Router) {
 		// mux.Use(Auth)
@@ -48,11 +75,44 @@ func routes(app *config.AppConfig) http.Handler {
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminCalendarReservations)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostCalendarReservations)
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
+		mux.Post("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Get("/reservations-trash", handlers.Repo.AdminTrashReservations)
+		mux.Post("/restore-reservation/{id}/do", handlers.Repo.AdminRestoreReservation)
+		mux.Post("/purge-reservation/{id}/do", handlers.Repo.AdminPurgeReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+
+		mux.Get("/mail-outbox", handlers.Repo.AdminMailOutbox)
+		mux.Get("/mail-outbox/{id}", handlers.Repo.AdminShowOutboxMail)
+		mux.Post("/mail-outbox/{id}/resend", handlers.Repo.AdminResendOutboxMail)
+
+		mux.Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		mux.Get("/email-templates/{name}", handlers.Repo.AdminShowEmailTemplate)
+		mux.Post("/email-templates/{name}", handlers.Repo.AdminPostEmailTemplate)
+		mux.Post("/email-templates/{name}/preview", handlers.Repo.AdminPreviewEmailTemplate)
+		mux.Post("/email-templates/{name}/test", handlers.Repo.AdminTestEmailTemplate)
+		mux.Post("/email-templates/{name}/reset", handlers.Repo.AdminResetEmailTemplate)
+
+		mux.Get("/notifications", handlers.Repo.AdminNotifications)
+		mux.Post("/notifications/read", handlers.Repo.AdminMarkNotificationsRead)
+		mux.Get("/notifications/settings", handlers.Repo.AdminNotificationSettings)
+		mux.Post("/notifications/settings", handlers.Repo.AdminPostNotificationSettings)
+
+		mux.Get("/webhooks", handlers.Repo.AdminWebhooks)
+		mux.Get("/webhooks/new", handlers.Repo.AdminNewWebhook)
+		mux.Post("/webhooks/new", handlers.Repo.AdminPostNewWebhook)
+		mux.Get("/webhooks/{id}", handlers.Repo.AdminShowWebhook)
+		mux.Post("/webhooks/{id}", handlers.Repo.AdminPostShowWebhook)
+		mux.Post("/webhooks/{id}/delete", handlers.Repo.AdminDeleteWebhook)
+		mux.Post("/webhooks/deliveries/{id}/replay", handlers.Repo.AdminReplayWebhookDelivery)
+
+		mux.Get("/jobs", handlers.Repo.AdminJobs)
+		mux.Post("/jobs/{id}/run", handlers.Repo.AdminRunJob)
+
+		mux.Get("/audit", handlers.Repo.AdminAuditLog)
+		mux.Get("/audit/export", handlers.Repo.AdminAuditLogExport)
 	})
 
 	return mux
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// serve runs srv on l until one of signals arrives, then stops accepting connections and waits
+// up to timeout for in-flight requests to finish. It returns nil after a clean shutdown, so the
+// caller can go on to stop the background workers and close the database
+func serve(srv *http.Server, l net.Listener, timeout time.Duration, signals ...os.Signal) error {
+	ctx, stop := signal.NotifyContext(context.Background(), signals...)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// a second signal kills the process straight away
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
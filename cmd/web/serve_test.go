@@ -0,0 +1,110 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// slowServer starts serve with a handler that blocks until release is closed
+func slowServer(t *testing.T, timeout time.Duration) (string, chan struct{}, chan struct{}, chan error) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(&http.Server{Handler: mux}, l, timeout, syscall.SIGTERM)
+	}()
+	return "http://" + l.Addr().String(), started, release, done
+}
+
+func TestServeShutsDownOnSignal(t *testing.T) {
+	addr, started, release, done := slowServer(t, 5*time.Second)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-started
+	err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected shutdown to wait for the request in flight, it returned %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	_, err = http.Get(addr + "/slow")
+	if err == nil {
+		t.Error("expected new connections to be refused while shutting down")
+	}
+
+	close(release)
+	if b := <-body; b != "done" {
+		t.Errorf("expected the request in flight to complete, got %q", b)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	addr, started, release, done := slowServer(t, 50*time.Millisecond)
+	defer close(release)
+
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the shutdown to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not give up on the request in flight")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/jobs"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
+)
+
+// purgeTrash returns the job permanently deleting reservations that have been in the trash
+// longer than the retention period
+func purgeTrash(db repository.DatabaseRepo) jobs.Handler {
+	return func(ctx context.Context, j models.Job) error {
+		n, err := db.WithContext(ctx).PurgeDeletedReservations(time.Now().Add(-app.TrashRetention))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			app.Logger.Info("purged reservations from trash", "count", n)
+		}
+		return nil
+	}
+}
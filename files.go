@@ -0,0 +1,22 @@
+// Package bookings holds the files the server reads at run time, embedded in the binary so it runs
+// from any directory
+package bookings
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+//go:embed templates static email-templates migrations
+var embedded embed.FS
+
+// Files returns the page templates, static assets, email templates and migrations, each in the
+// directory of that name. With dir set they are read from dir on disk instead of the binary, so
+// they can be edited while the server runs
+func Files(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return embedded
+}
@@ -0,0 +1,21 @@
+package bookings
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	for _, dir := range []string{"", "."} {
+		for _, name := range []string{
+			"templates/base.layout.tmpl",
+			"static/css/styles.css",
+			"email-templates/layout.html.tmpl",
+			"migrations/schema.sql",
+		} {
+			if _, err := fs.Stat(Files(dir), name); err != nil {
+				t.Errorf("with dir %q expected %s: %s", dir, name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// hashLength is the number of hex digits of the content hash put in fingerprinted names
+const hashLength = 12
+
+// brotliLevel trades startup time for size, the highest levels take seconds on the vendor bundles
+const brotliLevel = 9
+
+// compressible lists the extensions of text formats worth compressing, images and woff fonts
+// already are
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".html": true,
+	".txt":  true,
+	".ttf":  true,
+	".eot":  true,
+	".otf":  true,
+	".ico":  true,
+}
+
+// file is an asset with its compressed forms, kept when smaller than the original
+type file struct {
+	name        string
+	hash        string
+	contentType string
+	modTime     time.Time
+	plain       []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// entry is a file under one of its names
+type entry struct {
+	file      *file
+	immutable bool
+}
+
+// Pipeline serves static assets, each under its own name and under a fingerprinted name carrying
+// a hash of its content. Fingerprinted URLs change with the content, so they are cached for a year
+// without revalidation; plain names, still used by the relative links in stylesheets, are
+// revalidated with their ETag. Text assets are compressed with brotli and gzip once, at startup,
+// or when they change for a pipeline made by NewLive
+type Pipeline struct {
+	fsys   fs.FS
+	prefix string
+	live   bool
+
+	mu    sync.RWMutex
+	urls  map[string]string
+	files map[string]entry
+}
+
+// New reads, hashes and compresses every file of fsys, to be served under prefix, such as "/static/"
+func New(fsys fs.FS, prefix string) (*Pipeline, error) {
+	return build(fsys, prefix, false)
+}
+
+// NewLive is New for files edited while the application runs, as read from disk with -filesdir.
+// A file is checked whenever it is linked or served and hashed again once it changed, so pages
+// link its new content; files added or removed since are found or gone
+func NewLive(fsys fs.FS, prefix string) (*Pipeline, error) {
+	return build(fsys, prefix, true)
+}
+
+func build(fsys fs.FS, prefix string, live bool) (*Pipeline, error) {
+	p := &Pipeline{
+		fsys:   fsys,
+		prefix: prefix,
+		live:   live,
+		urls:   make(map[string]string),
+		files:  make(map[string]entry),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		f, err := load(fsys, name)
+		if err != nil {
+			return err
+		}
+		p.add(f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// add serves f under its name and its fingerprinted name, in place of the version it replaces.
+// The caller holds mu, or is the only one to know p
+func (p *Pipeline) add(f *file) {
+	p.remove(f.name)
+	fingerprinted := Fingerprint(f.name, f.hash)
+	p.urls[f.name] = p.prefix + fingerprinted
+	p.files[f.name] = entry{file: f}
+	p.files[fingerprinted] = entry{file: f, immutable: true}
+}
+
+// remove stops serving the asset name under both its names. The caller holds mu
+func (p *Pipeline) remove(name string) {
+	if e, ok := p.files[name]; ok && !e.immutable {
+		delete(p.files, Fingerprint(name, e.file.hash))
+		delete(p.files, name)
+		delete(p.urls, name)
+	}
+}
+
+// refresh returns the asset name of a live pipeline as it is now on disk, loading it again when its
+// modification time or size changed since it was loaded
+func (p *Pipeline) refresh(name string) (*file, bool) {
+	p.mu.RLock()
+	e, known := p.files[name]
+	p.mu.RUnlock()
+	if known && e.immutable {
+		return nil, false
+	}
+
+	info, err := fs.Stat(p.fsys, name)
+	if err != nil || info.IsDir() {
+		if known {
+			p.mu.Lock()
+			p.remove(name)
+			p.mu.Unlock()
+		}
+		return nil, false
+	}
+	if known && e.file.modTime.Equal(info.ModTime()) && int64(len(e.file.plain)) == info.Size() {
+		return e.file, true
+	}
+
+	f, err := load(p.fsys, name)
+	if err != nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	p.add(f)
+	p.mu.Unlock()
+	return f, true
+}
+
+// find returns the asset served under name, and whether name is its fingerprinted name. A live
+// pipeline answers a fingerprinted name of an earlier version with the current one, not immutable
+func (p *Pipeline) find(name string) (*file, bool, bool) {
+	p.mu.RLock()
+	e, ok := p.files[name]
+	p.mu.RUnlock()
+	if !p.live {
+		return e.file, e.immutable, ok
+	}
+
+	plain := name
+	if ok {
+		plain = e.file.name
+	} else if _, err := fs.Stat(p.fsys, name); err != nil {
+		plain = unfingerprint(name)
+	}
+
+	f, ok := p.refresh(plain)
+	if !ok {
+		return nil, false, false
+	}
+	return f, name != plain && name == Fingerprint(plain, f.hash), true
+}
+
+// load reads name from fsys along with its compressed forms
+func load(fsys fs.FS, name string) (*file, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b)
+	f := &file{
+		name:        name,
+		hash:        hex.EncodeToString(sum[:])[:hashLength],
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		modTime:     info.ModTime(),
+		plain:       b,
+	}
+	if f.contentType == "" {
+		f.contentType = http.DetectContentType(b)
+	}
+
+	if !compressible[strings.ToLower(path.Ext(name))] {
+		return f, nil
+	}
+
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := gw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(b) {
+		f.gzip = append([]byte(nil), buf.Bytes()...)
+	}
+
+	buf.Reset()
+	bw := brotli.NewWriterLevel(&buf, brotliLevel)
+	if _, err := bw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() < len(b) {
+		f.brotli = append([]byte(nil), buf.Bytes()...)
+	}
+
+	return f, nil
+}
+
+// Fingerprint returns name with hash inserted before its extension, so css/styles.css becomes
+// css/styles.<hash>.css
+func Fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// unfingerprint returns name without the hash Fingerprint put in it, or name when it has none
+func unfingerprint(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	hash := path.Ext(base)
+	if len(hash) != hashLength+1 {
+		return name
+	}
+	if _, err := hex.DecodeString(hash[1:]); err != nil {
+		return name
+	}
+	return strings.TrimSuffix(base, hash) + ext
+}
+
+// URL returns the fingerprinted URL of the asset name, such as css/styles.css, or its plain URL
+// when there is no such asset
+func (p *Pipeline) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if p.live {
+		p.refresh(name)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if u, ok := p.urls[name]; ok {
+		return u
+	}
+	return p.prefix + name
+}
+
+// ServeHTTP serves the asset named by the request path, once the prefix has been stripped, in the
+// best encoding the client accepts
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, immutable, ok := p.find(strings.TrimPrefix(r.URL.Path, "/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("Content-Type", f.contentType)
+
+	body, etag := f.plain, f.hash
+	if f.gzip != nil || f.brotli != nil {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	switch {
+	case f.brotli != nil && accepts(r, "br"):
+		body, etag = f.brotli, f.hash+"-br"
+		h.Set("Content-Encoding", "br")
+	case f.gzip != nil && accepts(r, "gzip"):
+		body, etag = f.gzip, f.hash+"-gzip"
+		h.Set("Content-Encoding", "gzip")
+	}
+	h.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, f.name, f.modTime, bytes.NewReader(body))
+}
+
+// accepts reports whether the Accept-Encoding header of r allows encoding
+func accepts(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+var css = strings.Repeat("body { margin: 0; padding: 0; }\n", 50)
+
+func newPipeline(t *testing.T) *Pipeline {
+	p, err := New(fstest.MapFS{
+		"css/styles.css":    {Data: []byte(css)},
+		"images/tray.png":   {Data: []byte("\x89PNG\r\n\x1a\n not really a png")},
+		"admin/js/app.js":   {Data: []byte("x")},
+		"admin/partials/nb": {Data: []byte("no extension")},
+	}, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func get(p *Pipeline, path, acceptEncoding string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestURL(t *testing.T) {
+	p := newPipeline(t)
+
+	u := p.URL("css/styles.css")
+	if !strings.HasPrefix(u, "/static/css/styles.") || !strings.HasSuffix(u, ".css") || len(u) != len("/static/css/styles..css")+hashLength {
+		t.Errorf("unexpected fingerprinted url %q", u)
+	}
+	if p.URL("/css/styles.css") != u {
+		t.Error("expected a leading slash to be ignored")
+	}
+	if p.URL("css/missing.css") != "/static/css/missing.css" {
+		t.Errorf("expected the plain url of a missing asset, got %q", p.URL("css/missing.css"))
+	}
+	if Fingerprint("admin/partials/nb", "abc") != "admin/partials/nb.abc" {
+		t.Errorf("unexpected fingerprint %q", Fingerprint("admin/partials/nb", "abc"))
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	p := newPipeline(t)
+	fingerprinted := strings.TrimPrefix(p.URL("css/styles.css"), "/static")
+
+	rr := get(p, fingerprinted, "gzip, deflate, br")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("expected brotli, got %d %q", rr.Code, rr.Header().Get("Content-Encoding"))
+	}
+	if rr.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("expected a fingerprinted asset to be immutable, got %q", rr.Header().Get("Cache-Control"))
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/css") || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("unexpected headers %v", rr.Header())
+	}
+	b, _ := ioutil.ReadAll(brotli.NewReader(rr.Body))
+	if string(b) != css {
+		t.Error("expected the brotli body to decode to the stylesheet")
+	}
+
+	rr = get(p, fingerprinted, "gzip, br;q=0")
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip when brotli is refused, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(zr)
+	if string(b) != css {
+		t.Error("expected the gzip body to decode to the stylesheet")
+	}
+
+	// plain names, used by relative links in stylesheets, are revalidated with their ETag
+	rr = get(p, "/css/styles.css", "")
+	etag := rr.Header().Get("ETag")
+	if rr.Body.String() != css || rr.Header().Get("Cache-Control") != "no-cache" || etag == "" {
+		t.Errorf("unexpected plain response %v", rr.Header())
+	}
+	if rr = get(p, "/css/styles.css", "", "If-None-Match", etag); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", rr.Code)
+	}
+
+	// images and files compression does not shrink are sent as they are
+	for _, path := range []string{"/images/tray.png", "/admin/js/app.js"} {
+		rr = get(p, path, "br, gzip")
+		if rr.Header().Get("Content-Encoding") != "" || rr.Header().Get("Vary") != "" {
+			t.Errorf("expected %s not to be compressed, got %v", path, rr.Header())
+		}
+	}
+	if !bytes.HasPrefix(get(p, "/images/tray.png", "").Body.Bytes(), []byte("\x89PNG")) {
+		t.Error("expected the image")
+	}
+
+	if rr = get(p, "/css/missing.css", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing asset, got %d", rr.Code)
+	}
+}
+
+func TestLive(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/styles.css": {Data: []byte(css), ModTime: time.Now().Add(-time.Hour)},
+	}
+	p, err := NewLive(fsys, "/static/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := p.URL("css/styles.css")
+
+	// the stylesheet is edited after startup
+	fsys["css/styles.css"] = &fstest.MapFile{Data: []byte("body { color: red; }"), ModTime: time.Now()}
+
+	after := p.URL("css/styles.css")
+	if after == before {
+		t.Fatalf("expected a new fingerprinted url once the file changed, still %q", after)
+	}
+	if rr := get(p, strings.TrimPrefix(after, "/static"), ""); rr.Body.String() != "body { color: red; }" ||
+		rr.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("expected the new content under the new url, got %q %v", rr.Body.String(), rr.Header())
+	}
+	if rr := get(p, strings.TrimPrefix(before, "/static"), ""); rr.Body.String() != "body { color: red; }" ||
+		rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected the old url to serve the new content without caching it, got %q %v", rr.Body.String(), rr.Header())
+	}
+
+	// files are found once added and gone once removed
+	fsys["js/app.js"] = &fstest.MapFile{Data: []byte("x"), ModTime: time.Now()}
+	if rr := get(p, "/js/app.js", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected a file added after startup to be served, got %d", rr.Code)
+	}
+	delete(fsys, "css/styles.css")
+	if rr := get(p, "/css/styles.css", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a removed file to be gone, got %d", rr.Code)
+	}
+	if p.URL("css/styles.css") != "/static/css/styles.css" {
+		t.Errorf("expected the plain url of a removed file, got %q", p.URL("css/styles.css"))
+	}
+}
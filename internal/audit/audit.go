@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// actions recorded in the audit log
+const (
+	ActionReservationUpdate  = "reservation.update"
+	ActionReservationProcess = "reservation.process"
+	ActionReservationDelete  = "reservation.delete"
+	ActionReservationRestore = "reservation.restore"
+	ActionReservationPurge   = "reservation.purge"
+	ActionBlockCreate        = "block.create"
+	ActionBlockDelete        = "block.delete"
+	ActionMailResend         = "mail.resend"
+	ActionEmailTemplateSave  = "email_template.save"
+	ActionEmailTemplateReset = "email_template.reset"
+	ActionJobRun             = "job.run"
+
+	ActionNotificationSettingsUpdate = "notification_settings.update"
+	ActionWebhookCreate              = "webhook.create"
+	ActionWebhookUpdate              = "webhook.update"
+	ActionWebhookDelete              = "webhook.delete"
+	ActionWebhookReplay              = "webhook.replay"
+)
+
+// entity types recorded in the audit log
+const (
+	EntityReservation   = "reservation"
+	EntityBlock         = "block"
+	EntityMail          = "mail"
+	EntityEmailTemplate = "email_template"
+	EntityJob           = "job"
+	EntityUser          = "user"
+	EntityWebhook       = "webhook"
+)
+
+// Change holds the old and new value of a changed field
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// New builds an audit log entry for action on an entity, storing before and after as JSON
+// along with the fields that changed between them. Either of before or after may be nil
+func New(action, entityType string, entityID int, before, after interface{}) (models.AuditLog, error) {
+	entry := models.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+	}
+
+	b, err := snapshot(before)
+	if err != nil {
+		return entry, err
+	}
+	a, err := snapshot(after)
+	if err != nil {
+		return entry, err
+	}
+	entry.Before = b
+	entry.After = a
+
+	diff, err := Diff(before, after)
+	if err != nil {
+		return entry, err
+	}
+	entry.Diff = diff
+
+	return entry, nil
+}
+
+// Diff returns a JSON object keyed by field name holding the from and to values of every
+// top level field that differs between before and after
+func Diff(before, after interface{}) (string, error) {
+	b, err := fields(before)
+	if err != nil {
+		return "", err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return "", err
+	}
+
+	changes := make(map[string]Change)
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changes[k] = Change{From: v, To: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = Change{From: nil, To: v}
+		}
+	}
+
+	if len(changes) == 0 {
+		return "", nil
+	}
+
+	out, err := json.Marshal(changes)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// snapshot marshals v to JSON, returning an empty string for nil
+func snapshot(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// fields decodes the JSON form of v into a map of its top level fields
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	if v == nil {
+		return m, nil
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(out, &m)
+	if err != nil {
+		return m, err
+	}
+	return m, nil
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type item struct {
+	Name  string
+	Count int
+}
+
+func TestDiff(t *testing.T) {
+	diff, err := Diff(item{Name: "a", Count: 1}, item{Name: "b", Count: 1})
+	if err != nil {
+		t.Error(err)
+	}
+
+	var changes map[string]Change
+	err = json.Unmarshal([]byte(diff), &changes)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("expected 1 change, got %d", len(changes))
+	}
+	if changes["Name"].From != "a" || changes["Name"].To != "b" {
+		t.Errorf("wrong change for Name: %v", changes["Name"])
+	}
+
+	diff, err = Diff(item{Name: "a"}, item{Name: "a"})
+	if err != nil {
+		t.Error(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff, got %s", diff)
+	}
+
+	diff, err = Diff(nil, item{Name: "a"})
+	if err != nil {
+		t.Error(err)
+	}
+	err = json.Unmarshal([]byte(diff), &changes)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("expected 2 changes for created item, got %d", len(changes))
+	}
+}
+
+func TestNew(t *testing.T) {
+	entry, err := New(ActionReservationDelete, EntityReservation, 1, item{Name: "a"}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if entry.Before == "" {
+		t.Error("expected before snapshot")
+	}
+	if entry.After != "" {
+		t.Errorf("expected empty after snapshot, got %s", entry.After)
+	}
+	if entry.EntityID != 1 || entry.Action != ActionReservationDelete {
+		t.Error("entry does not hold action and entity")
+	}
+}
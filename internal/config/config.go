@@ -2,19 +2,148 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
 
-	"github.com/DungBuiTien1999/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
+	"github.com/go-sql-driver/mysql"
 )
 
 // AppConfig holds application config
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	// FilesDir, when set, is the directory templates, static assets and email templates are read
+	// from instead of the binary, so they can be edited while the server runs
+	FilesDir string
+	// TemplateReload watches the templates in FilesDir and reloads the pages that change, refreshing
+	// the browser, for development
+	TemplateReload bool
+	// Logger is the application logger, handlers should log with the request logger from
+	// logging.FromContext so lines carry the request ID
+	Logger *slog.Logger
+	// LogFormat is text or json and LogLevel one of debug, info, warn or error
+	LogFormat    string
+	LogLevel     string
+	InProduction bool
+	Session      *scs.SessionManager
+	// Addr is the address the server listens on
+	Addr string
+	// ShutdownTimeout is how long shutdown waits for in-flight requests to finish
+	ShutdownTimeout time.Duration
+	Database        DatabaseConfig
+	// SessionStore is where sessions are kept: memory or mysql. Expired sessions are removed from
+	// the mysql store every SessionCleanup
+	SessionStore    string
+	SessionLifetime time.Duration
+	SessionCleanup  time.Duration
+	Features        FeatureConfig
+	Metrics         MetricsConfig
+	Tracing         TracingConfig
+	// CSPReportOnly sends the Content-Security-Policy as Content-Security-Policy-Report-Only, so
+	// violations are reported to /csp-report without anything being blocked
+	CSPReportOnly bool
+	RateLimit     RateLimitConfig
+	// BaseURL is the public address of the site, used to build links in mail
+	BaseURL string
+	// PropertyName and PropertyAddress describe the property in mail and calendar invites
+	PropertyName    string
+	PropertyAddress string
+	// TrashRetention is how long deleted reservations stay in the trash before they are purged
+	TrashRetention time.Duration
+	// MailTransport selects how mail is delivered: smtp, file or log
+	MailTransport string
+	// MailDir is where the file transport writes .eml files
+	MailDir string
+	// MailWorkers is the number of workers delivering mail from the outbox
+	MailWorkers int
+	// MailMaxAttempts is how many times delivery is tried before a message is dead
+	MailMaxAttempts int
+	// OwnerEmail is mailed every new booking by the staff notifications, on top of the subscribed
+	// staff. Empty mails nobody
+	OwnerEmail string
+	SMTP       SMTPConfig
+	// ReminderDays and CheckInInstructionsDays are how many days before arrival those messages are
+	// sent, FollowUpDays how many days after departure the thank you is. Zero turns a message off
+	ReminderDays            int
+	CheckInInstructionsDays int
+	FollowUpDays            int
+}
+
+// SMTPConfig holds the settings of the SMTP relay used to send mail
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Encryption is one of none, ssl or starttls
+	Encryption string
+	// From is the sender address used when a message does not set one
+	From string
+}
+
+// DatabaseConfig holds the settings of the MySQL connection
+type DatabaseConfig struct {
+	Host     string
+	Port     int
+	Name     string
+	User     string
+	Password string
+	// TLS is the tls parameter of the driver: true, false, skip-verify or preferred
+	TLS string
+	// ConnectAttempts is how many times connecting is tried at startup before giving up
+	ConnectAttempts int
+}
+
+// DSN returns the data source name to connect with
+func (d DatabaseConfig) DSN() string {
+	c := mysql.NewConfig()
+	c.Net = "tcp"
+	c.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	c.DBName = d.Name
+	c.User = d.User
+	c.Passwd = d.Password
+	c.TLSConfig = d.TLS
+	c.ParseTime = true
+	return c.FormatDSN()
+}
+
+// FeatureConfig turns optional parts of the application on and off, so that with several
+// instances only some of them run the background work
+type FeatureConfig struct {
+	// Jobs runs the scheduled jobs and the job queue
+	Jobs bool
+	// Webhooks posts events to the webhooks configured in the admin
+	Webhooks bool
+	// Notifications tells staff about events on their chosen channels
+	Notifications bool
+}
+
+// MetricsConfig holds the settings of the Prometheus /metrics endpoint. With Addr set it is
+// served on its own listener instead of the site, and with Username set it asks for basic auth
+type MetricsConfig struct {
+	Enabled  bool
+	Addr     string
+	Username string
+	Password string
+}
+
+// RateLimitConfig holds the rate limits of the route groups, written as requests/period such as
+// 30/1m, empty or off for none. Key is what clients are told apart by: ip, or session to keep
+// guests behind one proxy apart, falling back to the IP address before they have a session
+type RateLimitConfig struct {
+	Key string
+	// Search covers the availability searches, Reservation booking and Login signing in
+	Search      string
+	Reservation string
+	Login       string
+}
+
+// TracingConfig holds where OpenTelemetry spans are exported: none, stdout or otlp. Endpoint is
+// the OTLP/HTTP collector address, such as http://localhost:4318
+type TracingConfig struct {
+	Exporter string
+	Endpoint string
 }
@@ -0,0 +1,554 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/ratelimit"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the name of every environment variable read by Load
+const EnvPrefix = "BOOKINGS_"
+
+// redacted replaces secrets when the configuration is printed
+const redacted = "********"
+
+// setting is one configuration value. key is its name in the config file, with a dot between
+// section and name, its environment variable is derived from the key and flag is the command-line
+// flag setting it, if any
+type setting struct {
+	key    string
+	flag   string
+	usage  string
+	secret bool
+	field  func(c *AppConfig) interface{}
+}
+
+// settings lists every configuration value, in the order they are printed
+var settings = []setting{
+	{"addr", "addr", "Address the server listens on", false, func(c *AppConfig) interface{} { return &c.Addr }},
+	{"shutdown_timeout", "shutdowntimeout", "How long shutdown waits for in-flight requests to finish", false, func(c *AppConfig) interface{} { return &c.ShutdownTimeout }},
+	{"production", "production", "Application is in production", false, func(c *AppConfig) interface{} { return &c.InProduction }},
+	{"template_cache", "cache", "Use template cache", false, func(c *AppConfig) interface{} { return &c.UseCache }},
+	{"files_dir", "filesdir", "Read templates, static files and email templates from this directory instead of the binary", false, func(c *AppConfig) interface{} { return &c.FilesDir }},
+	{"template_reload", "reload", "Reload templates as they change in files_dir and refresh the browser, for development", false, func(c *AppConfig) interface{} { return &c.TemplateReload }},
+	{"base_url", "baseurl", "Public address of the site, used to build links in mail", false, func(c *AppConfig) interface{} { return &c.BaseURL }},
+	{"trash_retention", "trashretention", "How long deleted reservations are kept in the trash", false, func(c *AppConfig) interface{} { return &c.TrashRetention }},
+
+	{"log.format", "logformat", "Log format (text, json)", false, func(c *AppConfig) interface{} { return &c.LogFormat }},
+	{"log.level", "loglevel", "Lowest level logged (debug, info, warn, error)", false, func(c *AppConfig) interface{} { return &c.LogLevel }},
+
+	{"property.name", "propertyname", "Name of the property, used in mail and calendar invites", false, func(c *AppConfig) interface{} { return &c.PropertyName }},
+	{"property.address", "propertyaddress", "Address of the property, used as the location of calendar invites", false, func(c *AppConfig) interface{} { return &c.PropertyAddress }},
+
+	{"database.host", "dbhost", "Database host", false, func(c *AppConfig) interface{} { return &c.Database.Host }},
+	{"database.port", "dbport", "Database port", false, func(c *AppConfig) interface{} { return &c.Database.Port }},
+	{"database.name", "dbname", "Database name", false, func(c *AppConfig) interface{} { return &c.Database.Name }},
+	{"database.user", "dbuser", "Database user", false, func(c *AppConfig) interface{} { return &c.Database.User }},
+	{"database.password", "dbpass", "Database password", true, func(c *AppConfig) interface{} { return &c.Database.Password }},
+	{"database.tls", "dbssl", "Database ssl settings (true, false, skip-verify, preferred)", false, func(c *AppConfig) interface{} { return &c.Database.TLS }},
+
+	{"database.connect_attempts", "dbconnectattempts", "Times connecting to the database is tried at startup", false, func(c *AppConfig) interface{} { return &c.Database.ConnectAttempts }},
+
+	{"session.store", "sessionstore", "Session store (memory, mysql)", false, func(c *AppConfig) interface{} { return &c.SessionStore }},
+	{"session.lifetime", "sessionlifetime", "How long a session lasts", false, func(c *AppConfig) interface{} { return &c.SessionLifetime }},
+	{"session.cleanup_interval", "sessioncleanup", "Interval between removals of expired sessions from the database store", false, func(c *AppConfig) interface{} { return &c.SessionCleanup }},
+
+	{"mail.transport", "mailer", "Mail transport (smtp, file, log)", false, func(c *AppConfig) interface{} { return &c.MailTransport }},
+	{"mail.dir", "maildir", "Directory the file mail transport writes to", false, func(c *AppConfig) interface{} { return &c.MailDir }},
+	{"mail.from", "mailfrom", "Default From address of outgoing mail", false, func(c *AppConfig) interface{} { return &c.SMTP.From }},
+	{"mail.owner", "mailowner", "Address told about every new booking, empty for nobody", false, func(c *AppConfig) interface{} { return &c.OwnerEmail }},
+	{"mail.workers", "mailworkers", "Number of workers delivering mail from the outbox", false, func(c *AppConfig) interface{} { return &c.MailWorkers }},
+	{"mail.max_attempts", "mailmaxattempts", "Delivery attempts before a message is moved to the dead letter state", false, func(c *AppConfig) interface{} { return &c.MailMaxAttempts }},
+
+	{"smtp.host", "smtphost", "SMTP host", false, func(c *AppConfig) interface{} { return &c.SMTP.Host }},
+	{"smtp.port", "smtpport", "SMTP port", false, func(c *AppConfig) interface{} { return &c.SMTP.Port }},
+	{"smtp.username", "smtpuser", "SMTP username", false, func(c *AppConfig) interface{} { return &c.SMTP.Username }},
+	{"smtp.password", "smtppass", "SMTP password", true, func(c *AppConfig) interface{} { return &c.SMTP.Password }},
+	{"smtp.encryption", "smtpencryption", "SMTP encryption (none, ssl, starttls)", false, func(c *AppConfig) interface{} { return &c.SMTP.Encryption }},
+
+	{"reminders.reminder_days", "reminderdays", "Days before arrival the reminder email is sent, 0 turns it off", false, func(c *AppConfig) interface{} { return &c.ReminderDays }},
+	{"reminders.checkin_days", "checkindays", "Days before arrival the check-in instructions email is sent, 0 turns it off", false, func(c *AppConfig) interface{} { return &c.CheckInInstructionsDays }},
+	{"reminders.followup_days", "followupdays", "Days after departure the thank you email is sent, 0 turns it off", false, func(c *AppConfig) interface{} { return &c.FollowUpDays }},
+
+	{"metrics.enabled", "metrics", "Serve Prometheus metrics at /metrics", false, func(c *AppConfig) interface{} { return &c.Metrics.Enabled }},
+	{"metrics.addr", "metricsaddr", "Address to serve /metrics on instead of the site address", false, func(c *AppConfig) interface{} { return &c.Metrics.Addr }},
+	{"metrics.username", "metricsuser", "Basic auth username for /metrics", false, func(c *AppConfig) interface{} { return &c.Metrics.Username }},
+	{"metrics.password", "metricspass", "Basic auth password for /metrics", true, func(c *AppConfig) interface{} { return &c.Metrics.Password }},
+
+	{"tracing.exporter", "tracing", "Where spans are exported (none, stdout, otlp)", false, func(c *AppConfig) interface{} { return &c.Tracing.Exporter }},
+	{"tracing.endpoint", "tracingendpoint", "OTLP/HTTP collector address, by default the OTEL_EXPORTER_OTLP_ENDPOINT variables", false, func(c *AppConfig) interface{} { return &c.Tracing.Endpoint }},
+
+	{"csp.report_only", "cspreportonly", "Report Content-Security-Policy violations without blocking them", false, func(c *AppConfig) interface{} { return &c.CSPReportOnly }},
+
+	{"ratelimit.key", "ratelimitkey", "What rate limits tell clients apart by (ip, session)", false, func(c *AppConfig) interface{} { return &c.RateLimit.Key }},
+	{"ratelimit.search", "ratelimitsearch", "Availability searches allowed per client, such as 30/1m, or off", false, func(c *AppConfig) interface{} { return &c.RateLimit.Search }},
+	{"ratelimit.reservation", "ratelimitreservation", "Reservations allowed per client, such as 10/1m, or off", false, func(c *AppConfig) interface{} { return &c.RateLimit.Reservation }},
+	{"ratelimit.login", "ratelimitlogin", "Login attempts allowed per client, such as 10/1m, or off", false, func(c *AppConfig) interface{} { return &c.RateLimit.Login }},
+
+	{"features.jobs", "", "Run the background jobs", false, func(c *AppConfig) interface{} { return &c.Features.Jobs }},
+	{"features.webhooks", "", "Post events to the webhooks configured in the admin", false, func(c *AppConfig) interface{} { return &c.Features.Webhooks }},
+	{"features.notifications", "", "Tell staff about events on their chosen channels", false, func(c *AppConfig) interface{} { return &c.Features.Notifications }},
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() AppConfig {
+	return AppConfig{
+		Addr:            ":9090",
+		ShutdownTimeout: 30 * time.Second,
+		InProduction:    true,
+		UseCache:        true,
+		BaseURL:         "http://localhost:9090",
+		TrashRetention:  30 * 24 * time.Hour,
+		PropertyName:    "Fort Smythe Bed and Breakfast",
+		LogFormat:       "text",
+		LogLevel:        "info",
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            3306,
+			TLS:             "skip-verify",
+			ConnectAttempts: 10,
+		},
+		SessionStore:    "memory",
+		SessionLifetime: 24 * time.Hour,
+		SessionCleanup:  5 * time.Minute,
+		MailTransport:   "smtp",
+		MailDir:         "./tmp/mail",
+		MailWorkers:     2,
+		MailMaxAttempts: 8,
+		SMTP: SMTPConfig{
+			Host:       "localhost",
+			Port:       1025,
+			Encryption: "none",
+			From:       "bookingserver@gmail.com",
+		},
+		ReminderDays:            3,
+		CheckInInstructionsDays: 1,
+		FollowUpDays:            1,
+		Features: FeatureConfig{
+			Jobs:          true,
+			Webhooks:      true,
+			Notifications: true,
+		},
+		RateLimit: RateLimitConfig{
+			Key:         "ip",
+			Search:      "30/1m",
+			Reservation: "10/1m",
+			Login:       "10/1m",
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+	}
+}
+
+// EnvName returns the environment variable overriding the setting with the given key
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flagValue records the value of a flag so it can be applied after the file and the environment
+type flagValue struct {
+	value  string
+	isBool bool
+	set    bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *flagValue) Set(v string) error {
+	f.value = v
+	f.set = true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Load fills c with the defaults, then the config file named by the -config flag or the
+// BOOKINGS_CONFIG variable, then the environment and finally the flags in args, each overriding
+// the one before. fs gets a flag per setting and is parsed with args, lookupEnv is usually
+// os.LookupEnv. The loaded configuration is validated last, so on a *ValidationError c holds
+// everything that was loaded
+func Load(c *AppConfig, fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	*c = Default()
+
+	file := fs.String("config", "", "Config file (YAML), also read from "+EnvPrefix+"CONFIG")
+	flags := make(map[string]*flagValue)
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		_, isBool := s.field(c).(*bool)
+		f := &flagValue{value: s.get(c), isBool: isBool}
+		fs.Var(f, s.flag, s.usage)
+		flags[s.key] = f
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	path := *file
+	if path == "" {
+		path, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		err = loadFile(c, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range settings {
+		v, ok := lookupEnv(EnvName(s.key))
+		if !ok {
+			continue
+		}
+		err = s.set(c, v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", EnvName(s.key), err)
+		}
+	}
+
+	for _, s := range settings {
+		f := flags[s.key]
+		if f == nil || !f.set {
+			continue
+		}
+		err = s.set(c, f.value)
+		if err != nil {
+			return fmt.Errorf("-%s: %s", s.flag, err)
+		}
+	}
+
+	return Validate(c)
+}
+
+// loadFile applies the settings of a YAML config file. Sections can be nested or written as
+// dotted keys, unknown keys are an error so typos do not go unnoticed
+func loadFile(c *AppConfig, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file: %w", err)
+	}
+
+	var doc map[string]interface{}
+	err = yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return fmt.Errorf("can't parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	err = flatten("", doc, values)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s, ok := lookupSetting(k)
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, k)
+		}
+		err = s.set(c, values[k])
+		if err != nil {
+			return fmt.Errorf("config file %s: %s: %s", path, k, err)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested sections into dotted keys
+func flatten(prefix string, doc map[string]interface{}, values map[string]string) error {
+	for k, v := range doc {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch x := v.(type) {
+		case map[string]interface{}:
+			err := flatten(key, x, values)
+			if err != nil {
+				return err
+			}
+		case []interface{}:
+			return fmt.Errorf("%s: lists are not supported", key)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(x)
+		}
+	}
+	return nil
+}
+
+func lookupSetting(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// set parses v into the setting
+func (s setting) set(c *AppConfig, v string) error {
+	v = strings.TrimSpace(v)
+	switch p := s.field(c).(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", v)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s, 15m or 24h", v)
+		}
+		*p = d
+	}
+	return nil
+}
+
+// get returns the setting formatted the way set parses it
+func (s setting) get(c *AppConfig) string {
+	switch p := s.field(c).(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return p.String()
+	}
+	return ""
+}
+
+// describe names the setting the way an operator sets it
+func describe(key string) string {
+	s, _ := lookupSetting(key)
+	if s.flag == "" {
+		return fmt.Sprintf("%s (%s)", key, EnvName(key))
+	}
+	return fmt.Sprintf("%s (%s or -%s)", key, EnvName(key), s.flag)
+}
+
+// ValidationError lists the problems found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate checks c and returns every problem found at once as a *ValidationError
+func Validate(c *AppConfig) error {
+	var problems []string
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, describe(key)+" "+fmt.Sprintf(format, args...))
+	}
+	oneOf := func(key, v string, allowed ...string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		problem(key, "is %q, use one of %s", v, strings.Join(allowed, ", "))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		problem("addr", "is %q, use host:port or :port", c.Addr)
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problem("base_url", "is %q, use an http or https address", c.BaseURL)
+	}
+	if c.ShutdownTimeout <= 0 {
+		problem("shutdown_timeout", "must be longer than zero")
+	}
+	if c.TrashRetention <= 0 {
+		problem("trash_retention", "must be longer than zero")
+	}
+
+	oneOf("log.format", c.LogFormat, "text", "json")
+	oneOf("log.level", strings.ToLower(c.LogLevel), "debug", "info", "warn", "error")
+
+	if c.Database.Host == "" {
+		problem("database.host", "is required")
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		problem("database.port", "is %d, use a port between 1 and 65535", c.Database.Port)
+	}
+	if c.Database.Name == "" {
+		problem("database.name", "is required")
+	}
+	if c.Database.User == "" {
+		problem("database.user", "is required")
+	}
+	if c.Database.Password == "" {
+		problem("database.password", "is required")
+	}
+	if c.Database.ConnectAttempts < 1 {
+		problem("database.connect_attempts", "must be at least 1")
+	}
+	oneOf("database.tls", c.Database.TLS, "true", "false", "skip-verify", "preferred")
+
+	if c.TemplateReload {
+		if c.FilesDir == "" {
+			problem("template_reload", "needs files_dir, the embedded templates never change")
+		}
+		if c.InProduction {
+			problem("template_reload", "is for development, turn production off")
+		}
+	}
+
+	oneOf("session.store", c.SessionStore, "memory", "mysql")
+	if c.SessionLifetime <= 0 {
+		problem("session.lifetime", "must be longer than zero")
+	}
+	if c.SessionStore == "mysql" && c.SessionCleanup <= 0 {
+		problem("session.cleanup_interval", "must be longer than zero")
+	}
+
+	oneOf("mail.transport", c.MailTransport, "smtp", "file", "log")
+	if c.MailTransport == "file" && c.MailDir == "" {
+		problem("mail.dir", "is required by the file transport")
+	}
+	if c.MailWorkers < 1 {
+		problem("mail.workers", "must be at least 1")
+	}
+	if c.MailMaxAttempts < 1 {
+		problem("mail.max_attempts", "must be at least 1")
+	}
+	if c.MailTransport == "smtp" {
+		if c.SMTP.Host == "" {
+			problem("smtp.host", "is required by the smtp transport")
+		}
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			problem("smtp.port", "is %d, use a port between 1 and 65535", c.SMTP.Port)
+		}
+		oneOf("smtp.encryption", c.SMTP.Encryption, "none", "ssl", "starttls")
+	}
+
+	if c.Metrics.Enabled {
+		if c.Metrics.Addr != "" {
+			if _, _, err := net.SplitHostPort(c.Metrics.Addr); err != nil {
+				problem("metrics.addr", "is %q, use host:port or :port", c.Metrics.Addr)
+			}
+		} else if c.Metrics.Username == "" || c.Metrics.Password == "" {
+			problem("metrics.addr", "is required unless metrics.username and metrics.password are set, so /metrics is not public")
+		}
+		if c.Metrics.Username != "" && c.Metrics.Password == "" {
+			problem("metrics.password", "is required with metrics.username")
+		}
+	}
+
+	oneOf("ratelimit.key", c.RateLimit.Key, "ip", "session")
+	for key, limit := range map[string]string{
+		"ratelimit.search":      c.RateLimit.Search,
+		"ratelimit.reservation": c.RateLimit.Reservation,
+		"ratelimit.login":       c.RateLimit.Login,
+	} {
+		if _, err := ratelimit.ParseLimit(limit); err != nil {
+			problem(key, "%s", err)
+		}
+	}
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "otlp")
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("tracing.endpoint", "is %q, use an http or https address", c.Tracing.Endpoint)
+		}
+	}
+
+	for key, days := range map[string]int{
+		"reminders.reminder_days": c.ReminderDays,
+		"reminders.checkin_days":  c.CheckInInstructionsDays,
+		"reminders.followup_days": c.FollowUpDays,
+	} {
+		if days < 0 {
+			problem(key, "can't be negative")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return &ValidationError{Problems: problems}
+}
+
+// Print writes the configuration c as a YAML config file, with secrets redacted
+func Print(w io.Writer, c *AppConfig) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range settings {
+		parent, name := root, s.key
+		if i := strings.Index(s.key, "."); i > 0 {
+			section := s.key[:i]
+			name = s.key[i+1:]
+			parent = sections[section]
+			if parent == nil {
+				parent = &yaml.Node{Kind: yaml.MappingNode}
+				sections[section] = parent
+				root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, parent)
+			}
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: s.get(c)}
+		switch s.field(c).(type) {
+		case *int:
+			value.Tag = "!!int"
+		case *bool:
+			value.Tag = "!!bool"
+		default:
+			value.Tag = "!!str"
+		}
+		if s.secret && value.Value != "" {
+			value.Value = redacted
+		}
+
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, value)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err := enc.Encode(root)
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "bookings.yml")
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+addr: ":8080"
+database:
+  name: golangbookings
+  user: root
+  password: from-file
+  port: 3307
+session:
+  lifetime: 12h
+smtp.host: mail.here.com
+features:
+  jobs: false
+`)
+
+	var c AppConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	err := Load(&c, fs, []string{"-config", path, "-dbpass=from-flag", "-production=false"}, env(map[string]string{
+		"BOOKINGS_DATABASE_PASSWORD": "from-env",
+		"BOOKINGS_DATABASE_HOST":     "db.here.com",
+		"BOOKINGS_MAIL_WORKERS":      "4",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Addr != ":8080" || c.Database.Name != "golangbookings" || c.Database.Port != 3307 || c.SessionLifetime != 12*time.Hour || c.SMTP.Host != "mail.here.com" {
+		t.Errorf("expected the file to be applied, got %+v", c)
+	}
+	if c.Database.Host != "db.here.com" || c.MailWorkers != 4 {
+		t.Errorf("expected the environment to be applied, got %+v", c)
+	}
+	if c.Database.Password != "from-flag" || c.InProduction {
+		t.Errorf("expected flags to override the file and the environment, got %+v", c)
+	}
+	if c.Features.Jobs || !c.Features.Webhooks || c.SMTP.Port != 1025 {
+		t.Errorf("expected unset values to keep their defaults, got %+v", c.Features)
+	}
+
+	dsn := c.Database.DSN()
+	if !strings.HasPrefix(dsn, "root:from-flag@tcp(db.here.com:3307)/golangbookings?") || !strings.Contains(dsn, "parseTime=true") || !strings.Contains(dsn, "tls=skip-verify") {
+		t.Errorf("unexpected dsn %s", dsn)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	path := writeConfig(t, "database:\n  name: fromenvfile\n")
+
+	var c AppConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_ = Load(&c, fs, nil, env(map[string]string{"BOOKINGS_CONFIG": path}))
+	if c.Database.Name != "fromenvfile" {
+		t.Errorf("expected the file named by BOOKINGS_CONFIG to be read, got %q", c.Database.Name)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		file     string
+		env      map[string]string
+		expected string
+	}{
+		{"unknown setting", "database:\n  nmae: x\n", nil, `unknown setting "database.nmae"`},
+		{"bad yaml", "database: [", nil, "can't parse config file"},
+		{"bad value in file", "smtp:\n  port: twenty-five\n", nil, `smtp.port: "twenty-five" is not a whole number`},
+		{"bad value in environment", "", map[string]string{"BOOKINGS_PRODUCTION": "maybe"}, `BOOKINGS_PRODUCTION: "maybe" is not true or false`},
+		{"missing file", "", map[string]string{"BOOKINGS_CONFIG": "/no/such/bookings.yml"}, "can't read config file"},
+	}
+
+	for _, e := range tests {
+		vars := e.env
+		if vars == nil {
+			vars = make(map[string]string)
+		}
+		if e.file != "" {
+			vars["BOOKINGS_CONFIG"] = writeConfig(t, e.file)
+		}
+
+		var c AppConfig
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		err := Load(&c, fs, nil, env(vars))
+		if err == nil || !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("for %s, expected an error containing %q, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Database.Name = "golangbookings"
+	c.Database.User = "root"
+	c.Database.Password = "root"
+	if err := Validate(&c); err != nil {
+		t.Fatalf("expected the defaults with database credentials to be valid, got %s", err)
+	}
+
+	c.Database.Password = ""
+	c.Addr = "9090"
+	c.SessionStore = "redis"
+	c.ReminderDays = -1
+	c.Tracing.Endpoint = "localhost:4318"
+	c.TemplateReload = true
+
+	err := Validate(&c)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 7 {
+		t.Fatalf("expected 7 problems, got %v", err)
+	}
+	for _, want := range []string{"BOOKINGS_DATABASE_PASSWORD or -dbpass", "addr", `"redis"`, "reminders.reminder_days", "tracing.endpoint", "needs files_dir", "turn production off"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %s", want, err)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	c := Default()
+	c.Database.Password = "s3cret"
+	c.SMTP.Username = "mailer"
+
+	var out bytes.Buffer
+	err := Print(&out, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "s3cret") || !strings.Contains(out.String(), "********") {
+		t.Errorf("expected the password to be redacted, got\n%s", out.String())
+	}
+
+	// the printed configuration loads back to the same values
+	path := writeConfig(t, out.String())
+	var loaded AppConfig
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_ = Load(&loaded, fs, nil, env(map[string]string{"BOOKINGS_CONFIG": path}))
+	if loaded.SMTP.Username != "mailer" || loaded.TrashRetention != c.TrashRetention || loaded.SMTP.Password != "" {
+		t.Errorf("expected the printed config to load back, got %+v", loaded)
+	}
+}
@@ -0,0 +1,144 @@
+package csp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ReportPath is where browsers post the violations of the policy
+const ReportPath = "/csp-report"
+
+// ReportGroup names the reporting endpoint of the Reporting-Endpoints header in the report-to
+// directive
+const ReportGroup = "csp"
+
+// cdns are the hosts pages load scripts, stylesheets and fonts from besides the site
+const cdns = "https://cdn.jsdelivr.net https://unpkg.com"
+
+type contextKey int
+
+const nonceKey contextKey = 0
+
+// NewNonce returns a random nonce, to be used for a single response. It is URL safe base64, which
+// html/template writes in attributes as it is
+func NewNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// WithNonce returns ctx carrying the nonce of the response to the request
+func WithNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, nonceKey, nonce)
+}
+
+// Nonce returns the nonce inline scripts are tagged with, or an empty string outside a request
+func Nonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey).(string)
+	return nonce
+}
+
+// Policy returns the Content-Security-Policy allowing the scripts of the site and the CDNs, and
+// inline scripts only when they carry nonce. Stylesheets may be inline, as the date picker and
+// alert libraries style their elements in place. Images may come from any https host, so the
+// email template preview shows the pictures of a message
+func Policy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' " + cdns,
+		"style-src 'self' 'unsafe-inline' " + cdns,
+		"font-src 'self' data: " + cdns,
+		"img-src 'self' data: https:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+		"report-uri " + ReportPath,
+		"report-to " + ReportGroup,
+	}, "; ")
+}
+
+// Report is a violation of the policy as a browser reported it
+type Report struct {
+	DocumentURI string
+	BlockedURI  string
+	Directive   string
+	SourceFile  string
+	Line        int
+	// Disposition is enforce or report, when the policy was sent report-only
+	Disposition string
+}
+
+// ParseReports reads the violations in body, sent with contentType: application/csp-report for
+// the report-uri directive or application/reports+json for the Reporting API. Reports of other
+// types are skipped
+func ParseReports(contentType string, body []byte) ([]Report, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		var r struct {
+			Report struct {
+				DocumentURI        string `json:"document-uri"`
+				BlockedURI         string `json:"blocked-uri"`
+				ViolatedDirective  string `json:"violated-directive"`
+				EffectiveDirective string `json:"effective-directive"`
+				SourceFile         string `json:"source-file"`
+				Line               int    `json:"line-number"`
+				Disposition        string `json:"disposition"`
+			} `json:"csp-report"`
+		}
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, err
+		}
+		directive := r.Report.EffectiveDirective
+		if directive == "" {
+			directive = r.Report.ViolatedDirective
+		}
+		return []Report{{
+			DocumentURI: r.Report.DocumentURI,
+			BlockedURI:  r.Report.BlockedURI,
+			Directive:   directive,
+			SourceFile:  r.Report.SourceFile,
+			Line:        r.Report.Line,
+			Disposition: r.Report.Disposition,
+		}}, nil
+	case "application/reports+json":
+		var rs []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentURL        string `json:"documentURL"`
+				BlockedURL         string `json:"blockedURL"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				SourceFile         string `json:"sourceFile"`
+				Line               int    `json:"lineNumber"`
+				Disposition        string `json:"disposition"`
+			} `json:"body"`
+		}
+		if err := json.Unmarshal(body, &rs); err != nil {
+			return nil, err
+		}
+		var reports []Report
+		for _, r := range rs {
+			if r.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, Report{
+				DocumentURI: r.Body.DocumentURL,
+				BlockedURI:  r.Body.BlockedURL,
+				Directive:   r.Body.EffectiveDirective,
+				SourceFile:  r.Body.SourceFile,
+				Line:        r.Body.Line,
+				Disposition: r.Body.Disposition,
+			})
+		}
+		return reports, nil
+	default:
+		return nil, fmt.Errorf("unexpected report content type %q", contentType)
+	}
+}
@@ -0,0 +1,64 @@
+package csp
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNonce(t *testing.T) {
+	a, b := NewNonce(), NewNonce()
+	if len(a) != 22 || a == b {
+		t.Errorf("expected distinct 16 byte nonces, got %q and %q", a, b)
+	}
+
+	if Nonce(context.Background()) != "" {
+		t.Error("expected no nonce outside a request")
+	}
+	if Nonce(WithNonce(context.Background(), a)) != a {
+		t.Error("expected the nonce of the context")
+	}
+
+	policy := Policy(a)
+	for _, want := range []string{"script-src 'self' 'nonce-" + a + "' https://cdn.jsdelivr.net", "frame-ancestors 'none'", "report-uri /csp-report"} {
+		if !strings.Contains(policy, want) {
+			t.Errorf("expected %q in %q", want, policy)
+		}
+	}
+}
+
+func TestParseReports(t *testing.T) {
+	reports, err := ParseReports("application/csp-report", []byte(`{"csp-report": {
+		"document-uri": "https://example.com/admin/dashboard",
+		"blocked-uri": "inline",
+		"violated-directive": "script-src-elem",
+		"effective-directive": "script-src-elem",
+		"source-file": "https://example.com/admin/dashboard",
+		"line-number": 12,
+		"disposition": "report"
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].BlockedURI != "inline" || reports[0].Directive != "script-src-elem" || reports[0].Line != 12 {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	reports, err = ParseReports("application/reports+json", []byte(`[
+		{"type": "deprecation", "body": {}},
+		{"type": "csp-violation", "body": {"documentURL": "https://example.com/", "blockedURL": "https://evil.example/x.js", "effectiveDirective": "script-src-elem", "disposition": "enforce"}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].BlockedURI != "https://evil.example/x.js" || reports[0].Disposition != "enforce" {
+		t.Errorf("unexpected reports %+v", reports)
+	}
+
+	if _, err := ParseReports("text/plain", []byte("hello")); err == nil {
+		t.Error("expected an error for an unexpected content type")
+	}
+	if _, err := ParseReports("application/csp-report", []byte("{")); err == nil {
+		t.Error("expected an error for a malformed report")
+	}
+}
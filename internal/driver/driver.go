@@ -2,9 +2,11 @@ package driver
 
 import (
 	"database/sql"
+	"fmt"
+	"log/slog"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 // DB holds the database connection pool
@@ -18,23 +20,49 @@ const maxOpenDbConn = 10
 const maxIdleDbConn = 5
 const maxDbLifetime = 5 * time.Minute
 
-// ConnectSQL creates database pool for MySQL
-func ConnectSQL(dsn string) (*DB, error) {
+// Retry is how ConnectSQL waits for a database that is not reachable yet, as when the
+// application starts before it. The wait doubles after every failed attempt up to MaxWait
+type Retry struct {
+	Attempts int
+	Wait     time.Duration
+	MaxWait  time.Duration
+	Logger   *slog.Logger
+}
+
+// ConnectSQL creates database pool for MySQL. A malformed dsn fails straight away, a database
+// that does not answer is tried again as retry says
+func ConnectSQL(dsn string, retry Retry) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	d.SetMaxOpenConns(maxOpenDbConn)
 	d.SetMaxIdleConns(maxIdleDbConn)
 	d.SetConnMaxLifetime(maxDbLifetime)
 
-	dbConn.SQL = d
+	wait := retry.Wait
+	for attempt := 1; ; attempt++ {
+		err = testDb(d)
+		if err == nil {
+			break
+		}
+		if attempt >= retry.Attempts {
+			_ = d.Close()
+			return nil, fmt.Errorf("database not reachable after %d attempts: %w", attempt, err)
+		}
 
-	if err = testDb(dbConn.SQL); err != nil {
-		return nil, err
+		if retry.Logger != nil {
+			retry.Logger.Warn("database not reachable, retrying", "attempt", attempt, "wait", wait, "error", err)
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if retry.MaxWait > 0 && wait > retry.MaxWait {
+			wait = retry.MaxWait
+		}
 	}
 
+	dbConn.SQL = d
 	return dbConn, nil
 }
 
@@ -46,16 +74,12 @@ func testDb(d *sql.DB) error {
 	return nil
 }
 
-// NewDatabase creates a new database for application
+// NewDatabase creates a new database pool for application, without connecting to it
 func NewDatabase(dsn string) (*sql.DB, error) {
-	conn, err := sql.Open("mysql", dsn)
+	_, err := mysql.ParseDSN(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = conn.Ping(); err != nil {
-		return nil, err
-	}
-
-	return conn, nil
+	return sql.Open("mysql", dsn)
 }
@@ -0,0 +1,26 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectSQLBadDSN(t *testing.T) {
+	_, err := ConnectSQL("not a dsn", Retry{Attempts: 3, Wait: time.Millisecond})
+	if err == nil {
+		t.Error("expected an error for a malformed dsn")
+	}
+}
+
+func TestConnectSQLRetries(t *testing.T) {
+	start := time.Now()
+	// nothing listens on port 1
+	_, err := ConnectSQL("user:pass@tcp(127.0.0.1:1)/bookings?timeout=1s", Retry{Attempts: 3, Wait: 10 * time.Millisecond, MaxWait: 15 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("expected the connection to be given up after 3 attempts, got %v", err)
+	}
+	if waited := time.Since(start); waited < 25*time.Millisecond {
+		t.Errorf("expected backoff between attempts, took %s", waited)
+	}
+}
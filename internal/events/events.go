@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// event types
+const (
+	ReservationCreated   = "reservation.created"
+	ReservationUpdated   = "reservation.updated"
+	ReservationCancelled = "reservation.cancelled"
+	ReservationRestored  = "reservation.restored"
+	ContactMessage       = "contact.message"
+	BlockCreated         = "block.created"
+	BlockDeleted         = "block.deleted"
+)
+
+// Type describes an event type to staff choosing what they are told about
+type Type struct {
+	Name  string
+	Label string
+}
+
+// Types lists the event types staff can subscribe to, in the order they are shown
+var Types = []Type{
+	{ReservationCreated, "New booking"},
+	{ReservationCancelled, "Cancellation"},
+	{ReservationRestored, "Restored booking"},
+	{ReservationUpdated, "Modification"},
+	{ContactMessage, "Contact message"},
+}
+
+// WebhookTypes lists the event types webhook endpoints can subscribe to, in the order they are shown
+var WebhookTypes = []Type{
+	{ReservationCreated, "Reservation created"},
+	{ReservationUpdated, "Reservation updated"},
+	{ReservationCancelled, "Reservation cancelled"},
+	{ReservationRestored, "Reservation restored"},
+	{BlockCreated, "Block created"},
+	{BlockDeleted, "Block deleted"},
+}
+
+// Event is something that happened in the application. Reservation events carry the reservation,
+// with Previous holding it as it was before an update, contact events carry the message and block
+// events the room restriction blocking the room
+type Event struct {
+	Type        string
+	OccurredAt  time.Time
+	Reservation models.Reservation
+	Previous    models.Reservation
+	Message     models.ContactMessage
+	Block       models.RoomRestriction
+}
+
+// Subscriber handles the events it subscribed to. ctx is the context of the request that published
+// the event, carrying its trace for the work the subscriber queues
+type Subscriber func(ctx context.Context, e Event) error
+
+type subscription struct {
+	types      map[string]bool
+	subscriber Subscriber
+}
+
+// Bus hands published events to their subscribers. Subscribers run in the publishing goroutine,
+// one after the other, so they should queue slow work rather than do it
+type Bus struct {
+	Logger *slog.Logger
+
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+// NewBus returns a bus with no subscribers
+func NewBus(logger *slog.Logger) *Bus {
+	return &Bus{Logger: logger}
+}
+
+// Subscribe has s called with every event of the given types, or with every event when no type is given
+func (b *Bus) Subscribe(s Subscriber, types ...string) {
+	sub := subscription{subscriber: s}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool)
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+// Publish hands e to its subscribers. A subscriber failing is logged and never stops the others,
+// nor the action that published the event
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now()
+	}
+
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+
+	for _, sub := range subscriptions {
+		if sub.types != nil && !sub.types[e.Type] {
+			continue
+		}
+		err := sub.subscriber(ctx, e)
+		if err != nil && b.Logger != nil {
+			b.Logger.Error("event subscriber failed", "event", e.Type, "error", err)
+		}
+	}
+}
+
+// reservationPayload is a reservation as it appears in the JSON of an event
+type reservationPayload struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+}
+
+func newReservationPayload(r models.Reservation) *reservationPayload {
+	return &reservationPayload{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format("2006-01-02"),
+		EndDate:   r.EndDate.Format("2006-01-02"),
+		RoomID:    r.RoomID,
+		RoomName:  r.Room.RoomName,
+	}
+}
+
+type messagePayload struct {
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	Message       string `json:"message"`
+}
+
+type blockPayload struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+type payload struct {
+	Type        string              `json:"type"`
+	OccurredAt  time.Time           `json:"occurred_at"`
+	Reservation *reservationPayload `json:"reservation,omitempty"`
+	Previous    *reservationPayload `json:"previous,omitempty"`
+	Message     *messagePayload     `json:"message,omitempty"`
+	Block       *blockPayload       `json:"block,omitempty"`
+}
+
+// JSON returns e as sent to webhooks
+func (e Event) JSON() ([]byte, error) {
+	p := payload{
+		Type:       e.Type,
+		OccurredAt: e.OccurredAt.UTC(),
+	}
+	switch {
+	case strings.HasPrefix(e.Type, "reservation."):
+		p.Reservation = newReservationPayload(e.Reservation)
+		if e.Type == ReservationUpdated {
+			p.Previous = newReservationPayload(e.Previous)
+		}
+	case strings.HasPrefix(e.Type, "block."):
+		p.Block = &blockPayload{
+			ID:        e.Block.ID,
+			RoomID:    e.Block.RoomID,
+			RoomName:  e.Block.Room.RoomName,
+			StartDate: e.Block.StartDate.Format("2006-01-02"),
+			EndDate:   e.Block.EndDate.Format("2006-01-02"),
+		}
+	case e.Type == ContactMessage:
+		p.Message = &messagePayload{
+			Name:          e.Message.Name,
+			Email:         e.Message.Email,
+			ReservationID: e.Message.ReservationID,
+			Message:       e.Message.Message,
+		}
+	}
+	return json.Marshal(p)
+}
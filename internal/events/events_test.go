@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+func TestBusPublish(t *testing.T) {
+	b := NewBus(nil)
+
+	var all, cancellations []string
+	b.Subscribe(func(ctx context.Context, e Event) error {
+		all = append(all, e.Type)
+		return errors.New("this one always fails")
+	})
+	b.Subscribe(func(ctx context.Context, e Event) error {
+		if e.OccurredAt.IsZero() {
+			t.Error("expected the occurrence time to be set")
+		}
+		cancellations = append(cancellations, e.Type)
+		return nil
+	}, ReservationCancelled)
+
+	b.Publish(context.Background(), Event{Type: ReservationCreated})
+	b.Publish(context.Background(), Event{Type: ReservationCancelled})
+
+	if len(all) != 2 {
+		t.Errorf("expected 2 events for the catch-all subscriber, got %v", all)
+	}
+	if len(cancellations) != 1 || cancellations[0] != ReservationCancelled {
+		t.Errorf("expected only the cancellation, got %v", cancellations)
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	e := Event{
+		Type:       ReservationUpdated,
+		OccurredAt: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC),
+		Reservation: models.Reservation{
+			ID:        7,
+			FirstName: "John",
+			StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+			Room:      models.Room{RoomName: "Major's Suite"},
+		},
+		Previous: models.Reservation{ID: 7, FirstName: "Jon"},
+	}
+
+	b, err := e.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := got["reservation"].(map[string]interface{})
+	prev, _ := got["previous"].(map[string]interface{})
+	if got["type"] != ReservationUpdated || res["start_date"] != "2050-01-10" || res["room_name"] != "Major's Suite" || prev["first_name"] != "Jon" {
+		t.Errorf("unexpected payload %s", b)
+	}
+	if _, ok := got["message"]; ok {
+		t.Errorf("expected no message in a reservation event, got %s", b)
+	}
+
+	b, _ = Event{Type: BlockDeleted, Block: models.RoomRestriction{ID: 3, RoomID: 2, StartDate: time.Date(2050, 2, 1, 0, 0, 0, 0, time.UTC)}}.JSON()
+	got = nil
+	_ = json.Unmarshal(b, &got)
+	block, _ := got["block"].(map[string]interface{})
+	if _, ok := got["reservation"]; ok || block["start_date"] != "2050-02-01" {
+		t.Errorf("unexpected block payload %s", b)
+	}
+
+	b, _ = Event{Type: ContactMessage, Message: models.ContactMessage{Name: "Ann", Message: "Hi"}}.JSON()
+	got = nil
+	_ = json.Unmarshal(b, &got)
+	if _, ok := got["reservation"]; ok || got["message"] == nil {
+		t.Errorf("unexpected contact payload %s", b)
+	}
+}
@@ -1,23 +1,35 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/DungBuiTien1999/bookings/internal/audit"
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/csp"
 	"github.com/DungBuiTien1999/bookings/internal/driver"
+	"github.com/DungBuiTien1999/bookings/internal/events"
 	"github.com/DungBuiTien1999/bookings/internal/forms"
 	"github.com/DungBuiTien1999/bookings/internal/helpers"
+	"github.com/DungBuiTien1999/bookings/internal/ical"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
 	"github.com/DungBuiTien1999/bookings/internal/models"
 	"github.com/DungBuiTien1999/bookings/internal/render"
 	"github.com/DungBuiTien1999/bookings/internal/repository"
 	"github.com/DungBuiTien1999/bookings/internal/repository/dbrepo"
+	"github.com/DungBuiTien1999/bookings/internal/webhooks"
 )
 
 // Repo the repository used by the handlers
@@ -25,22 +37,25 @@ var Repo *Repository
 
 // Repository is the repository type
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App    *config.AppConfig
+	DB     repository.DatabaseRepo
+	Events *events.Bus
 }
 
 // NewRepo create a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewMySQLRepo(db.SQL, a),
+		App:    a,
+		DB:     dbrepo.NewMySQLRepo(db.SQL, a),
+		Events: events.NewBus(a.Logger),
 	}
 }
 
 func NewTestingRepo(a *config.AppConfig) *Repository {
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
+		App:    a,
+		DB:     dbrepo.NewTestingRepo(a),
+		Events: events.NewBus(a.Logger),
 	}
 }
 
@@ -49,6 +64,11 @@ func NewHandlers(r *Repository) {
 	Repo = r
 }
 
+// db returns the database repository bound to r, so its calls are traced as part of the request
+func (m *Repository) db(r *http.Request) repository.DatabaseRepo {
+	return m.DB.WithContext(r.Context())
+}
+
 // Contact renders the home page
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
@@ -80,7 +100,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.db(r).GetRoomByID(res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't find room")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -124,7 +144,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	room, err := m.DB.GetRoomByID(reservation.RoomID)
+	room, err := m.db(r).GetRoomByID(reservation.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get room from session")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -176,58 +196,38 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(res)
-	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
-	}
-
 	restriction := models.RoomRestriction{
 		StartDate:     startDate,
 		EndDate:       endDate,
 		RoomID:        roomID,
-		ReservationID: newReservationID,
 		RestrictionID: 1,
 	}
-	err = m.DB.InsertRoomRestriction(restriction)
+
+	// the confirmation goes into the mail outbox in the same transaction as the reservation, it is
+	// built once its id is known so the calendar invite and manage link can refer to it. Staff are
+	// told about the booking once the event is published
+	newReservationID, err := m.db(r).InsertReservationWithMail(res, restriction, func(id int) ([]models.MailData, error) {
+		booked := res
+		booked.ID = id
+
+		guestMsg, err := mailrender.ReservationMail(mailrender.Confirmation, booked.Email, booked)
+		if err != nil {
+			return nil, err
+		}
+		guestMsg.Attachments = append(guestMsg.Attachments, mailrender.ReservationInvite(ical.MethodRequest, booked))
+		return []models.MailData{guestMsg}, nil
+	})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into database")
+		logging.FromContext(r.Context()).Error("can't insert reservation", "room_id", roomID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	res.ID = newReservationID
+	logging.Annotate(r.Context(), slog.Int("reservation_id", res.ID))
+	metrics.ReservationsCreated.Inc()
 
-	// send notifications - first to guest
-	htmlMsg := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br />
-		<p>Dear %s:</p>
-		<p>This is confirm your reservation from %s to %s.</p>
-	`, res.FirstName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To:       res.Email,
-		From:     "bookingserver@gmail.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMsg,
-		Template: "basic.html",
-	}
-	m.App.MailChan <- msg
-
-	// send notifications - second to owner
-	htmlMsg = fmt.Sprintf(`
-		<strong>Reservation Notification</strong><br />
-		<p>Dear owner:</p>
-		<p>A reservation has been made for %s from %s to %s</p>
-	`, res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
-
-	msg = models.MailData{
-		To:      "owner@gmail.com",
-		From:    "bookingserver@gmail.com",
-		Subject: "Reservation Notification",
-		Content: htmlMsg,
-	}
-	m.App.MailChan <- msg
+	m.Events.Publish(r.Context(), events.Event{Type: events.ReservationCreated, Reservation: res})
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -236,8 +236,56 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 // Contact renders the contact page
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	var msg models.ContactMessage
+	msg.ReservationID, _ = strconv.Atoi(r.URL.Query().Get("reservation"))
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostContact handles the posting of the contact form, passing the message on to staff
+func (m *Repository) PostContact(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+
+	msg := models.ContactMessage{
+		Name:    strings.TrimSpace(r.Form.Get("name")),
+		Email:   strings.TrimSpace(r.Form.Get("email")),
+		Message: strings.TrimSpace(r.Form.Get("message")),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "email", "message")
+	form.IsEmail("email")
+	if reservation := strings.TrimSpace(r.Form.Get("reservation")); reservation != "" {
+		msg.ReservationID, err = strconv.Atoi(reservation)
+		if err != nil || msg.ReservationID <= 0 {
+			form.Errors.Add("reservation", "Invalid reservation number")
+		}
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["message"] = msg
+		render.Template(w, r, "contact.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
 
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+	m.Events.Publish(r.Context(), events.Event{Type: events.ContactMessage, Message: msg})
+
+	m.App.Session.Put(r.Context(), "flash", "Thanks for your message, we'll get back to you soon")
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
 }
 
 // Availability renders the search for availability room page
@@ -270,12 +318,13 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.db(r).SearchAvailabilityForAllRooms(startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "have error while finding available room")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
+	metrics.Search(metrics.SearchAllRooms, len(rooms) > 0)
 
 	if len(rooms) == 0 {
 		// No Availability
@@ -330,7 +379,7 @@ func (m *Repository) PostAvailabilityJSON(w http.ResponseWriter, r *http.Request
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.db(r).SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
 	if err != nil {
 		resp := jsonResponse{
 			OK:      false,
@@ -341,6 +390,8 @@ func (m *Repository) PostAvailabilityJSON(w http.ResponseWriter, r *http.Request
 		w.Write(out)
 		return
 	}
+	metrics.Search(metrics.SearchRoom, available)
+
 	resp := jsonResponse{
 		OK:        available,
 		Message:   "",
@@ -416,7 +467,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.db(r).GetRoomByID(roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't connect to database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -445,7 +496,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't parse login form", "error", err)
 		return
 	}
 
@@ -472,7 +523,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.db(r).Authenticate(email, password)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -492,6 +543,80 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// NotFound answers requests no route matches with the 404 page
+func (m *Repository) NotFound(w http.ResponseWriter, r *http.Request) {
+	helpers.ClientError(w, r, http.StatusNotFound)
+}
+
+// MethodNotAllowed answers requests whose route does not take their method
+func (m *Repository) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	helpers.ClientError(w, r, http.StatusMethodNotAllowed)
+}
+
+// maxCSPReportSize is the largest violation report read, reports carry a sample of the script at most
+const maxCSPReportSize = 64 << 10
+
+// CSPReport logs the Content-Security-Policy violations browsers report
+func (m *Repository) CSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	reports, err := csp.ParseReports(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	logger := logging.FromContext(r.Context())
+	for _, report := range reports {
+		logger.Warn("content security policy violation",
+			"document_uri", report.DocumentURI,
+			"blocked_uri", report.BlockedURI,
+			"directive", report.Directive,
+			"source_file", report.SourceFile,
+			"line", report.Line,
+			"disposition", report.Disposition,
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TooManyRequests tells a client over a rate limit how long to wait, as set in Retry-After
+func (m *Repository) TooManyRequests(w http.ResponseWriter, r *http.Request) {
+	stringMap := map[string]string{"retry_after": retryAfter(w)}
+	render.TemplateStatus(w, r, http.StatusTooManyRequests, "too-many-requests.page.tmpl", &models.TemplateData{StringMap: stringMap})
+}
+
+// TooManyRequestsJSON answers the JSON availability search of a client over a rate limit
+func (m *Repository) TooManyRequestsJSON(w http.ResponseWriter, r *http.Request) {
+	resp := jsonResponse{
+		OK:      false,
+		Message: "Too many searches, please wait " + retryAfter(w) + " and try again",
+	}
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(out)
+}
+
+// retryAfter describes the Retry-After header of the response
+func retryAfter(w http.ResponseWriter) string {
+	seconds, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	switch {
+	case err != nil || seconds <= 1:
+		return "a moment"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	case seconds < 120:
+		return "a minute"
+	default:
+		return fmt.Sprintf("%d minutes", (seconds+59)/60)
+	}
+}
+
 // AdminDashboard is dashboard page for admin
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
@@ -499,9 +624,9 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 
 // AdminNewReservations shows new reservations on dashboard page
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.db(r).AllNewReservations()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	data := make(map[string]interface{})
@@ -513,9 +638,9 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 
 // AdminAllReservations shows all reservations on dashboard page
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.db(r).AllReservations()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	data := make(map[string]interface{})
@@ -530,14 +655,15 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	exploted := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploted[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	// get reservation from database
-	res, err := m.DB.GetReservationByID(id)
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -551,8 +677,15 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 	stringMap["month"] = month
 
+	notifications, err := m.db(r).NotificationsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["notifications"] = notifications
 
 	render.Template(w, r, "admin-reservation-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -565,23 +698,26 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	exploted := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploted[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	// get reservation from database
-	res, err := m.DB.GetReservationByID(id)
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
+	before := res
+
 	src := exploted[3]
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
@@ -594,7 +730,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 	if !form.Valid() {
-		helpers.ServerError(w, errors.New("invalid form data"))
+		helpers.ServerError(w, r, errors.New("invalid form data"))
 		return
 	}
 
@@ -603,12 +739,26 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.db(r).UpdateReservation(res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
+	m.audit(r, audit.ActionReservationUpdate, audit.EntityReservation, res.ID, before, res)
+
+	m.Events.Publish(r.Context(), events.Event{Type: events.ReservationUpdated, Reservation: res, Previous: before})
+
+	if res.Email != before.Email && before.Email != "" {
+		// take the stay out of the calendar of the old address, it is sent to the new one below
+		old := res
+		old.Email = before.Email
+		m.enqueueGuestMail(r, mailrender.Change, ical.MethodCancel, old)
+	}
+	if res.FirstName != before.FirstName || res.LastName != before.LastName || res.Email != before.Email {
+		m.enqueueGuestMail(r, mailrender.Change, ical.MethodRequest, res)
+	}
+
 	year := r.Form.Get("year")
 	month := r.Form.Get("month")
 
@@ -663,9 +813,9 @@ func (m *Repository) AdminCalendarReservations(w http.ResponseWriter, r *http.Re
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.db(r).AllRooms()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	data["rooms"] = rooms
@@ -681,9 +831,9 @@ func (m *Repository) AdminCalendarReservations(w http.ResponseWriter, r *http.Re
 		}
 
 		// get all restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.db(r).GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
-			helpers.ServerError(w, err)
+			helpers.ServerError(w, r, err)
 			return
 		}
 
@@ -715,7 +865,7 @@ func (m *Repository) AdminCalendarReservations(w http.ResponseWriter, r *http.Re
 func (m *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -723,9 +873,9 @@ func (m *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *htt
 	month, _ := strconv.Atoi(r.Form.Get("m"))
 
 	// process blocks
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.db(r).AllRooms()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	form := forms.New(r.PostForm)
@@ -738,10 +888,21 @@ func (m *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *htt
 			if value > 0 {
 				if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
 					// delete the restriction by id
-					err := m.DB.DeleteBlockByID(value)
+					err := m.db(r).DeleteBlockByID(value)
 					if err != nil {
-						log.Println(err)
+						logging.FromContext(r.Context()).Error("can't delete block", "block_id", value, "room_id", x.ID, "error", err)
+						continue
 					}
+					m.audit(r, audit.ActionBlockDelete, audit.EntityBlock, value, blockSnapshot(x.ID, name), nil)
+
+					date, _ := time.Parse("2006-01-2", name)
+					m.Events.Publish(r.Context(), events.Event{Type: events.BlockDeleted, Block: models.RoomRestriction{
+						ID:        value,
+						RoomID:    x.ID,
+						StartDate: date,
+						EndDate:   date,
+						Room:      x,
+					}})
 				}
 			}
 		}
@@ -754,10 +915,20 @@ func (m *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *htt
 			roomID, _ := strconv.Atoi(exploded[2])
 			t, _ := time.Parse("2006-01-2", exploded[3])
 			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
+			blockID, err := m.db(r).InsertBlockForRoom(roomID, t)
 			if err != nil {
-				log.Println(err)
+				logging.FromContext(r.Context()).Error("can't insert block", "room_id", roomID, "date", exploded[3], "error", err)
+				continue
+			}
+			m.audit(r, audit.ActionBlockCreate, audit.EntityBlock, blockID, nil, blockSnapshot(roomID, exploded[3]))
+
+			block := models.RoomRestriction{ID: blockID, RoomID: roomID, StartDate: t, EndDate: t}
+			for _, x := range rooms {
+				if x.ID == roomID {
+					block.Room = x
+				}
 			}
+			m.Events.Publish(r.Context(), events.Event{Type: events.BlockCreated, Block: block})
 		}
 	}
 
@@ -767,18 +938,39 @@ func (m *Repository) AdminPostCalendarReservations(w http.ResponseWriter, r *htt
 
 // AdminProcessReservation masks reservation as processed
 func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	exploted := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploted[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	src := exploted[3]
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).UpdateProcessedForReservation(id, 1)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	after := res
+	after.Processed = 1
+	m.audit(r, audit.ActionReservationProcess, audit.EntityReservation, id, res, after)
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
 
 	m.App.Session.Put(r.Context(), "flash", "Reservation maskes as processed")
 
@@ -792,20 +984,58 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 
 // AdminDeleteReservation deletes a reservation
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	exploted := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploted[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	src := exploted[3]
 
-	_ = m.DB.DeleteReservation(id)
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// a reservation already in the trash was cancelled before, so it is neither audited, published
+	// nor mailed to the guest again
+	trashed := !res.DeletedAt.IsZero()
+	if !trashed {
+		err = m.db(r).DeleteReservation(id)
+		// moved to the trash since it was read
+		trashed = errors.Is(err, sql.ErrNoRows)
+		if err != nil && !trashed {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	if trashed {
+		m.App.Session.Put(r.Context(), "error", "Reservation is already in the trash")
+	} else {
+		m.audit(r, audit.ActionReservationDelete, audit.EntityReservation, id, res, nil)
+
+		m.Events.Publish(r.Context(), events.Event{Type: events.ReservationCancelled, Reservation: res})
+
+		m.enqueueGuestMail(r, mailrender.Cancellation, ical.MethodCancel, res)
+
+		m.App.Session.Put(r.Context(), "flash", "Reservation moved to trash")
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
+	year := r.Form.Get("y")
+	month := r.Form.Get("m")
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -813,3 +1043,1172 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
 	}
 }
+
+// AdminTrashReservations shows reservations in the trash
+func (m *Repository) AdminTrashReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.db(r).AllDeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	purgeDates := make(map[int]time.Time)
+	for _, x := range reservations {
+		purgeDates[x.ID] = x.DeletedAt.Add(m.App.TrashRetention)
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["purge_dates"] = purgeDates
+	render.Template(w, r, "admin-trash-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRestoreReservation takes a reservation out of the trash if its room is still free
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).RestoreReservation(id)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Can't restore reservation, the room is no longer available for those dates")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	after := res
+	after.DeletedAt = time.Time{}
+	m.audit(r, audit.ActionReservationRestore, audit.EntityReservation, id, res, after)
+
+	m.Events.Publish(r.Context(), events.Event{Type: events.ReservationRestored, Reservation: after})
+
+	// the guest was told about the cancellation, so confirm the stay again and put it back in their calendar
+	m.enqueueGuestMail(r, mailrender.Confirmation, ical.MethodRequest, after)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored")
+	http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+}
+
+// AdminPurgeReservation permanently deletes a reservation in the trash
+func (m *Repository) AdminPurgeReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	logging.Annotate(r.Context(), slog.Int("reservation_id", id))
+	res, err := m.db(r).GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// only reservations in the trash can go, an active one is left alone
+	if res.DeletedAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "Only reservations in the trash can be permanently deleted")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+
+	err = m.db(r).PurgeReservation(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// restored or purged since it was read
+		m.App.Session.Put(r.Context(), "error", "Only reservations in the trash can be permanently deleted")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionReservationPurge, audit.EntityReservation, id, res, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation permanently deleted")
+	http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+}
+
+// AdminMailOutbox shows messages in the mail outbox, optionally filtered by status
+func (m *Repository) AdminMailOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	mail, err := m.db(r).AllOutboxMail(status)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	data := make(map[string]interface{})
+	data["mail"] = mail
+	data["statuses"] = []string{models.MailStatusPending, models.MailStatusSending, models.MailStatusSent, models.MailStatusDead}
+
+	render.Template(w, r, "admin-mail-outbox.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminShowOutboxMail shows a message from the mail outbox
+func (m *Repository) AdminShowOutboxMail(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	mail, err := m.db(r).GetOutboxMailByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["mail"] = mail
+
+	render.Template(w, r, "admin-mail-show.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendOutboxMail queues a message from the mail outbox for delivery again
+func (m *Repository) AdminResendOutboxMail(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	mail, err := m.db(r).GetOutboxMailByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).ResendOutboxMail(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	after := mail
+	after.Status = models.MailStatusPending
+	after.Attempts = 0
+	m.audit(r, audit.ActionMailResend, audit.EntityMail, id, outboxSnapshot(mail), outboxSnapshot(after))
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued for delivery")
+	http.Redirect(w, r, "/admin/mail-outbox", http.StatusSeeOther)
+}
+
+// emailTemplateRow is a message type on the email templates page
+type emailTemplateRow struct {
+	Name       string
+	Subject    string
+	Customized bool
+	UpdatedAt  time.Time
+}
+
+// emailTemplatePreview is the JSON response of the email template preview and test send
+type emailTemplatePreview struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// AdminEmailTemplates lists the transactional email templates and whether admins have edited them
+func (m *Repository) AdminEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	edited, err := m.db(r).AllEmailTemplates()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rows []emailTemplateRow
+	for _, name := range mailrender.Names {
+		def, err := mailrender.Default(name)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		row := emailTemplateRow{Name: name, Subject: def.Subject}
+		for _, t := range edited {
+			if t.Name == name {
+				row.Subject = t.Subject
+				row.Customized = true
+				row.UpdatedAt = t.UpdatedAt
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	data := make(map[string]interface{})
+	data["templates"] = rows
+
+	render.Template(w, r, "admin-email-templates.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowEmailTemplate shows the editor of an email template, filled with the edited version
+// when there is one and with the built-in one otherwise
+func (m *Repository) AdminShowEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	name, err := emailTemplateName(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	t, err := m.db(r).GetEmailTemplate(name)
+	customized := err == nil
+	if errors.Is(err, sql.ErrNoRows) {
+		t, err = mailrender.Default(name)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(url.Values{})
+	form.Set("subject", t.Subject)
+	form.Set("html_body", t.HTMLBody)
+	form.Set("text_body", t.TextBody)
+
+	m.renderEmailTemplateEditor(w, r, name, customized, form)
+}
+
+// AdminPostEmailTemplate saves the edited version of an email template
+func (m *Repository) AdminPostEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	name, err := emailTemplateName(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	before, err := m.db(r).GetEmailTemplate(name)
+	customized := err == nil
+	if errors.Is(err, sql.ErrNoRows) {
+		before, err = mailrender.Default(name)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("subject", "html_body", "text_body")
+	t := emailTemplateFromForm(name, form)
+	if form.Valid() {
+		if err := mailrender.Validate(t); err != nil {
+			form.Errors.Add("template", err.Error())
+		}
+	}
+	if !form.Valid() {
+		m.renderEmailTemplateEditor(w, r, name, customized, form)
+		return
+	}
+
+	t.ID, err = m.db(r).UpsertEmailTemplate(t)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionEmailTemplateSave, audit.EntityEmailTemplate, t.ID, emailTemplateSnapshot(before), emailTemplateSnapshot(t))
+
+	m.App.Session.Put(r.Context(), "flash", "Email template saved")
+	http.Redirect(w, r, "/admin/email-templates", http.StatusSeeOther)
+}
+
+// AdminResetEmailTemplate removes the edited version of an email template, so the built-in one is used again
+func (m *Repository) AdminResetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	name, err := emailTemplateName(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	before, err := m.db(r).GetEmailTemplate(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "flash", "Email template already uses the default")
+		http.Redirect(w, r, "/admin/email-templates", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).DeleteEmailTemplate(name)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionEmailTemplateReset, audit.EntityEmailTemplate, before.ID, emailTemplateSnapshot(before), nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Email template reset to the default")
+	http.Redirect(w, r, "/admin/email-templates", http.StatusSeeOther)
+}
+
+// AdminPreviewEmailTemplate renders the posted, unsaved version of an email template against a
+// sample reservation and sends it back as JSON
+func (m *Repository) AdminPreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	resp, _ := m.renderPostedEmailTemplate(r)
+	writeEmailTemplatePreview(w, resp)
+}
+
+// AdminTestEmailTemplate queues the posted, unsaved version of an email template, rendered against a
+// sample reservation, for delivery to the given address
+func (m *Repository) AdminTestEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	resp, msg := m.renderPostedEmailTemplate(r)
+	if !resp.OK {
+		writeEmailTemplatePreview(w, resp)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.IsEmail("to")
+	if !form.Valid() {
+		resp.OK = false
+		resp.Message = "Enter the address the test email is sent to"
+		writeEmailTemplatePreview(w, resp)
+		return
+	}
+
+	msg.To = r.Form.Get("to")
+	msg.Subject = "[Test] " + msg.Subject
+	err := m.db(r).EnqueueMail(msg)
+	if err != nil {
+		resp.OK = false
+		resp.Message = "Error connecting to database"
+		writeEmailTemplatePreview(w, resp)
+		return
+	}
+
+	resp.Message = fmt.Sprintf("Test email queued for %s", msg.To)
+	writeEmailTemplatePreview(w, resp)
+}
+
+// renderPostedEmailTemplate renders the email template posted by the editor against the sample reservation
+func (m *Repository) renderPostedEmailTemplate(r *http.Request) (emailTemplatePreview, models.MailData) {
+	var resp emailTemplatePreview
+	var msg models.MailData
+
+	err := r.ParseForm()
+	if err != nil {
+		resp.Message = "Internal server error"
+		return resp, msg
+	}
+
+	name, err := emailTemplateName(r)
+	if err != nil {
+		resp.Message = "Unknown email template"
+		return resp, msg
+	}
+
+	msg, err = mailrender.Execute(emailTemplateFromForm(name, forms.New(r.PostForm)), "", mailrender.SampleData())
+	if err != nil {
+		resp.Message = err.Error()
+		return resp, msg
+	}
+
+	resp.OK = true
+	resp.Subject = msg.Subject
+	resp.HTML = msg.Content
+	resp.Text = msg.PlainContent
+	return resp, msg
+}
+
+// renderEmailTemplateEditor shows the email template editor with the values of form
+func (m *Repository) renderEmailTemplateEditor(w http.ResponseWriter, r *http.Request, name string, customized bool, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["name"] = name
+
+	data := make(map[string]interface{})
+	data["customized"] = customized
+	data["variables"] = mailrender.Variables
+
+	if id := m.App.Session.GetInt(r.Context(), "user_id"); id > 0 {
+		if u, err := m.db(r).GetUserByID(id); err == nil {
+			stringMap["test_to"] = u.Email
+		}
+	}
+
+	render.Template(w, r, "admin-email-template-edit.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+func writeEmailTemplatePreview(w http.ResponseWriter, resp emailTemplatePreview) {
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// emailTemplateName returns the message type named in the url, which must be one of the known ones
+func emailTemplateName(r *http.Request) (string, error) {
+	exploted := strings.Split(r.URL.Path, "/")
+	if len(exploted) < 4 {
+		return "", errors.New("missing email template name")
+	}
+	for _, name := range mailrender.Names {
+		if name == exploted[3] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown email template %q", exploted[3])
+}
+
+// emailTemplateFromForm builds the name email template from the editor form
+func emailTemplateFromForm(name string, form *forms.Form) models.EmailTemplate {
+	return models.EmailTemplate{
+		Name:     name,
+		Subject:  strings.TrimSpace(form.Get("subject")),
+		HTMLBody: form.Get("html_body"),
+		TextBody: form.Get("text_body"),
+	}
+}
+
+// emailTemplateSnapshot describes an email template for the audit log
+func emailTemplateSnapshot(t models.EmailTemplate) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      t.Name,
+		"subject":   t.Subject,
+		"html_body": t.HTMLBody,
+		"text_body": t.TextBody,
+	}
+}
+
+// feedLength is how many entries of the notification feed are shown
+const feedLength = 50
+
+// notificationChannels lists the channels staff choose from, in the order they are shown
+var notificationChannels = []struct {
+	Name  string
+	Label string
+}{
+	{models.ChannelEmail, "Email"},
+	{models.ChannelWebhook, "Webhook"},
+	{models.ChannelFeed, "In-app feed"},
+}
+
+// AdminNotifications shows the notification feed of the logged in user
+func (m *Repository) AdminNotifications(w http.ResponseWriter, r *http.Request) {
+	items, err := m.db(r).FeedForUser(m.App.Session.GetInt(r.Context(), "user_id"), feedLength)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["items"] = items
+
+	render.Template(w, r, "admin-notifications.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminMarkNotificationsRead marks the notification feed of the logged in user as read
+func (m *Repository) AdminMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	err := m.db(r).MarkFeedRead(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
+
+// AdminNotificationSettings shows which staff are told about which events, and how
+func (m *Repository) AdminNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	staff, err := m.db(r).AllStaff()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	subscriptions, err := m.db(r).AllNotificationSubscriptions()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	subscribed := make(map[string]bool)
+	for _, s := range subscriptions {
+		subscribed[subscriptionKey(s.UserID, s.EventType, s.Channel)] = true
+	}
+
+	m.renderNotificationSettings(w, r, staff, subscribed, forms.New(nil))
+}
+
+// AdminPostNotificationSettings saves the notification settings of every staff member
+func (m *Repository) AdminPostNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	staff, err := m.db(r).AllStaff()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	existing, err := m.db(r).AllNotificationSubscriptions()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	wasSubscribed := make(map[string]bool)
+	for _, s := range existing {
+		wasSubscribed[subscriptionKey(s.UserID, s.EventType, s.Channel)] = true
+	}
+
+	subscribed := make(map[string]bool)
+	for _, key := range r.Form["subscribe"] {
+		subscribed[key] = true
+	}
+
+	form := forms.New(r.PostForm)
+	previous := make([]models.User, len(staff))
+	copy(previous, staff)
+
+	for i, u := range staff {
+		field := fmt.Sprintf("webhook_url_%d", u.ID)
+		staff[i].WebhookURL = strings.TrimSpace(r.Form.Get(field))
+
+		if staff[i].WebhookURL != "" {
+			target, err := url.Parse(staff[i].WebhookURL)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				form.Errors.Add(field, "Enter an http or https address")
+			}
+		}
+
+		for _, t := range events.Types {
+			if !subscribed[subscriptionKey(u.ID, t.Name, models.ChannelWebhook)] {
+				continue
+			}
+			// webhook notifications are posted by the webhook dispatcher
+			if !m.App.Features.Webhooks {
+				form.Errors.Add(field, "Webhooks are turned off, choose another channel")
+			} else if staff[i].WebhookURL == "" {
+				form.Errors.Add(field, "Enter an address to be notified by webhook")
+			}
+			break
+		}
+	}
+
+	if !form.Valid() {
+		m.renderNotificationSettings(w, r, staff, subscribed, form)
+		return
+	}
+
+	// only the staff whose settings changed are saved and audited
+	for i, u := range staff {
+		before := notificationSettingsSnapshot(previous[i].WebhookURL, u.ID, wasSubscribed)
+		after := notificationSettingsSnapshot(u.WebhookURL, u.ID, subscribed)
+		if fmt.Sprint(before) == fmt.Sprint(after) {
+			continue
+		}
+
+		var subscriptions []models.NotificationSubscription
+		for _, t := range events.Types {
+			for _, c := range notificationChannels {
+				if subscribed[subscriptionKey(u.ID, t.Name, c.Name)] {
+					subscriptions = append(subscriptions, models.NotificationSubscription{
+						UserID:    u.ID,
+						EventType: t.Name,
+						Channel:   c.Name,
+					})
+				}
+			}
+		}
+
+		err = m.db(r).UpdateNotificationSettings(u.ID, u.WebhookURL, subscriptions)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		m.audit(r, audit.ActionNotificationSettingsUpdate, audit.EntityUser, u.ID, before, after)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Notification settings saved")
+	http.Redirect(w, r, "/admin/notifications/settings", http.StatusSeeOther)
+}
+
+// renderNotificationSettings renders the notification settings page with the given choices
+func (m *Repository) renderNotificationSettings(w http.ResponseWriter, r *http.Request, staff []models.User, subscribed map[string]bool, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["staff"] = staff
+	data["subscribed"] = subscribed
+	data["event_types"] = events.Types
+	data["channels"] = notificationChannels
+
+	render.Template(w, r, "admin-notification-settings.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// subscriptionKey is the value of the checkbox subscribing a user to an event on a channel
+func subscriptionKey(userID int, eventType, channel string) string {
+	return fmt.Sprintf("%d:%s:%s", userID, eventType, channel)
+}
+
+// webhookDeliveriesShown is how many of the latest deliveries are shown with a webhook
+const webhookDeliveriesShown = 50
+
+// AdminWebhooks lists the webhook endpoints
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := m.db(r).AllWebhooks()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["webhooks"] = hooks
+
+	render.Template(w, r, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewWebhook shows the form adding a webhook endpoint
+func (m *Repository) AdminNewWebhook(w http.ResponseWriter, r *http.Request) {
+	m.renderWebhookEditor(w, r, models.Webhook{Active: true}, nil, forms.New(nil))
+}
+
+// AdminPostNewWebhook adds a webhook endpoint with a new secret
+func (m *Repository) AdminPostNewWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	hook, form := webhookFromForm(r, models.Webhook{})
+	if !form.Valid() {
+		m.renderWebhookEditor(w, r, hook, nil, form)
+		return
+	}
+
+	hook.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	hook.ID, err = m.db(r).InsertWebhook(hook)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionWebhookCreate, audit.EntityWebhook, hook.ID, nil, webhookSnapshot(hook))
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook added, use its secret to check the signature of deliveries")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", hook.ID), http.StatusSeeOther)
+}
+
+// AdminShowWebhook shows a webhook endpoint and its latest deliveries
+func (m *Repository) AdminShowWebhook(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	hook, err := m.db(r).GetWebhookByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	deliveries, err := m.db(r).DeliveriesForWebhook(id, webhookDeliveriesShown)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.renderWebhookEditor(w, r, hook, deliveries, forms.New(nil))
+}
+
+// AdminPostShowWebhook updates a webhook endpoint, giving it a new secret when asked to
+func (m *Repository) AdminPostShowWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	before, err := m.db(r).GetWebhookByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	hook, form := webhookFromForm(r, before)
+	if !form.Valid() {
+		deliveries, err := m.db(r).DeliveriesForWebhook(id, webhookDeliveriesShown)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.renderWebhookEditor(w, r, hook, deliveries, form)
+		return
+	}
+
+	if r.Form.Get("rotate_secret") != "" {
+		hook.Secret, err = webhooks.NewSecret()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	err = m.db(r).UpdateWebhook(hook)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionWebhookUpdate, audit.EntityWebhook, id, webhookSnapshot(before), webhookSnapshot(hook))
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", id), http.StatusSeeOther)
+}
+
+// AdminDeleteWebhook deletes a webhook endpoint and its delivery log
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	hook, err := m.db(r).GetWebhookByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.db(r).DeleteWebhook(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionWebhookDelete, audit.EntityWebhook, id, webhookSnapshot(hook), nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook deleted")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminReplayWebhookDelivery posts the payload of a delivery to its webhook again
+func (m *Repository) AdminReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[4])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	delivery, err := m.db(r).GetWebhookDeliveryByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	newID, err := m.db(r).ReplayWebhookDelivery(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.audit(r, audit.ActionWebhookReplay, audit.EntityWebhook, delivery.WebhookID, nil, map[string]interface{}{
+		"delivery_id": id,
+		"replay_id":   newID,
+		"event_type":  delivery.EventType,
+	})
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Delivery %d queued again as delivery %d", id, newID))
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", delivery.WebhookID), http.StatusSeeOther)
+}
+
+// renderWebhookEditor renders the page adding or editing hook, with its deliveries
+func (m *Repository) renderWebhookEditor(w http.ResponseWriter, r *http.Request, hook models.Webhook, deliveries []models.WebhookDelivery, form *forms.Form) {
+	subscribed := make(map[string]bool)
+	for _, e := range hook.Events {
+		subscribed[e] = true
+	}
+
+	data := make(map[string]interface{})
+	data["webhook"] = hook
+	data["subscribed"] = subscribed
+	data["event_types"] = events.WebhookTypes
+	data["deliveries"] = deliveries
+
+	render.Template(w, r, "admin-webhook-edit.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// webhookFromForm returns hook with the posted address, description, events and state, and the form
+// holding any validation errors
+func webhookFromForm(r *http.Request, hook models.Webhook) (models.Webhook, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("url")
+
+	hook.URL = strings.TrimSpace(r.Form.Get("url"))
+	hook.Description = strings.TrimSpace(r.Form.Get("description"))
+	hook.Active = r.Form.Get("active") != ""
+
+	if hook.URL != "" {
+		target, err := url.Parse(hook.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			form.Errors.Add("url", "Enter an http or https address")
+		}
+	}
+
+	// the webhook of a staff member posts the notifications chosen in their settings
+	if hook.UserID != 0 {
+		return hook, form
+	}
+
+	posted := make(map[string]bool)
+	for _, e := range r.Form["events"] {
+		posted[e] = true
+	}
+	hook.Events = nil
+	for _, t := range events.WebhookTypes {
+		if posted[t.Name] {
+			hook.Events = append(hook.Events, t.Name)
+		}
+	}
+	if len(hook.Events) == 0 {
+		form.Errors.Add("events", "Choose at least one event")
+	}
+
+	return hook, form
+}
+
+// AdminJobs shows the background jobs and their state
+func (m *Repository) AdminJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := m.db(r).AllJobs()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["jobs"] = jobs
+
+	render.Template(w, r, "admin-jobs.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRunJob makes a background job due straight away
+func (m *Repository) AdminRunJob(w http.ResponseWriter, r *http.Request) {
+	exploted := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploted[3])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	job, err := m.db(r).GetJobByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if job.Status == models.JobStatusRunning {
+		m.App.Session.Put(r.Context(), "error", "Job is already running")
+		http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+		return
+	}
+
+	err = m.db(r).RunJobNow(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	after := job
+	after.Status = models.JobStatusScheduled
+	after.Attempts = 0
+	m.audit(r, audit.ActionJobRun, audit.EntityJob, id, jobSnapshot(job), jobSnapshot(after))
+
+	m.App.Session.Put(r.Context(), "flash", "Job will run shortly")
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
+
+// AdminAuditLog shows the audit log of admin actions
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	logs, err := m.db(r).AllAuditLogs(auditFilter(r))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// the export link carries the filters, written out whole so the template keeps it as it is
+	q := r.URL.Query()
+	stringMap := make(map[string]string)
+	export := url.Values{}
+	for _, key := range []string{"user_id", "action", "entity_type", "entity_id", "from", "to"} {
+		stringMap[key] = q.Get(key)
+		if v := q.Get(key); v != "" {
+			export.Set(key, v)
+		}
+	}
+	stringMap["export_url"] = "/admin/audit/export"
+	if len(export) > 0 {
+		stringMap["export_url"] += "?" + export.Encode()
+	}
+
+	data := make(map[string]interface{})
+	data["logs"] = logs
+	data["actions"] = []string{
+		audit.ActionReservationUpdate,
+		audit.ActionReservationProcess,
+		audit.ActionReservationDelete,
+		audit.ActionReservationRestore,
+		audit.ActionReservationPurge,
+		audit.ActionBlockCreate,
+		audit.ActionBlockDelete,
+		audit.ActionMailResend,
+		audit.ActionEmailTemplateSave,
+		audit.ActionEmailTemplateReset,
+		audit.ActionJobRun,
+		audit.ActionNotificationSettingsUpdate,
+		audit.ActionWebhookCreate,
+		audit.ActionWebhookUpdate,
+		audit.ActionWebhookDelete,
+		audit.ActionWebhookReplay,
+	}
+	data["entity_types"] = []string{
+		audit.EntityReservation, audit.EntityBlock, audit.EntityMail, audit.EntityEmailTemplate,
+		audit.EntityJob, audit.EntityUser, audit.EntityWebhook,
+	}
+
+	render.Template(w, r, "admin-audit.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminAuditLogExport sends the filtered audit log as a CSV file
+func (m *Repository) AdminAuditLogExport(w http.ResponseWriter, r *http.Request) {
+	logs, err := m.db(r).AllAuditLogs(auditFilter(r))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.csv", time.Now().Format("20060102-150405")))
+
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "created_at", "user_id", "user_email", "action", "entity_type", "entity_id", "ip_address", "before", "after", "diff"})
+	for _, x := range logs {
+		_ = cw.Write([]string{
+			strconv.Itoa(x.ID),
+			x.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(x.UserID),
+			csvCell(x.User.Email),
+			x.Action,
+			x.EntityType,
+			strconv.Itoa(x.EntityID),
+			csvCell(x.IPAddress),
+			csvCell(x.Before),
+			csvCell(x.After),
+			csvCell(x.Diff),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logging.FromContext(r.Context()).Error("can't write audit log export", "error", err)
+	}
+}
+
+// csvCell keeps a spreadsheet from reading s as a formula, quoting the cells that start like one
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// enqueueGuestMail queues the name message about res for res.Email, with a calendar invite sent
+// with method attached. Each invite takes the next sequence of the reservation, so it supersedes
+// the ones sent before it. A failure is logged and never stops the action
+func (m *Repository) enqueueGuestMail(r *http.Request, name, method string, res models.Reservation) {
+	seq, err := m.db(r).NextInviteSequence(res.ID)
+	if err != nil {
+		// the mail still goes, calendars may just keep the events as they were
+		logging.FromContext(r.Context()).Error("can't take invite sequence", "reservation_id", res.ID, "error", err)
+	}
+	res.InviteSequence = seq
+
+	msg, err := mailrender.ReservationMail(name, res.Email, res)
+	if err == nil {
+		msg.Attachments = append(msg.Attachments, mailrender.ReservationInvite(method, res))
+		err = m.db(r).EnqueueMail(msg)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't queue guest mail", "template", name, "reservation_id", res.ID, "error", err)
+	}
+}
+
+// audit records an admin action in the audit log. A failure to record is logged and never stops the action
+func (m *Repository) audit(r *http.Request, action, entityType string, entityID int, before, after interface{}) {
+	entry, err := audit.New(action, entityType, entityID, before, after)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't build audit entry", "action", action, "error", err)
+		return
+	}
+	entry.UserID = m.App.Session.GetInt(r.Context(), "user_id")
+	entry.IPAddress = helpers.ClientIP(r)
+
+	err = m.db(r).InsertAuditLog(entry)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't record audit entry", "action", action, "entity_id", entityID, "error", err)
+	}
+}
+
+// auditFilter builds an audit log filter from the query string. The to date is inclusive
+func auditFilter(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+	layout := "2006-01-02"
+
+	var f models.AuditFilter
+	f.UserID, _ = strconv.Atoi(q.Get("user_id"))
+	f.Action = q.Get("action")
+	f.EntityType = q.Get("entity_type")
+	f.EntityID, _ = strconv.Atoi(q.Get("entity_id"))
+	if from, err := time.Parse(layout, q.Get("from")); err == nil {
+		f.From = from
+	}
+	if to, err := time.Parse(layout, q.Get("to")); err == nil {
+		f.To = to.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+// outboxSnapshot describes a message in the mail outbox for the audit log, leaving out its content
+func outboxSnapshot(x models.OutboxMail) map[string]interface{} {
+	return map[string]interface{}{
+		"to":       x.Mail.To,
+		"subject":  x.Mail.Subject,
+		"status":   x.Status,
+		"attempts": x.Attempts,
+	}
+}
+
+// jobSnapshot describes a background job for the audit log
+func jobSnapshot(j models.Job) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     j.Name,
+		"status":   j.Status,
+		"attempts": j.Attempts,
+	}
+}
+
+// notificationSettingsSnapshot describes the notification settings of a user for the audit log, the
+// events it is subscribed to listed with their channels
+func notificationSettingsSnapshot(webhookURL string, userID int, subscribed map[string]bool) map[string]interface{} {
+	channels := make(map[string][]string)
+	for _, t := range events.Types {
+		for _, c := range notificationChannels {
+			if subscribed[subscriptionKey(userID, t.Name, c.Name)] {
+				channels[t.Name] = append(channels[t.Name], c.Name)
+			}
+		}
+	}
+	return map[string]interface{}{
+		"webhook_url":   webhookURL,
+		"subscriptions": channels,
+	}
+}
+
+// webhookSnapshot describes a webhook for the audit log, leaving out its secret
+func webhookSnapshot(w models.Webhook) map[string]interface{} {
+	return map[string]interface{}{
+		"url":         w.URL,
+		"description": w.Description,
+		"events":      strings.Join(w.Events, ","),
+		"active":      w.Active,
+		// a fingerprint shows the secret was rotated without revealing it
+		"secret_hash": fmt.Sprintf("%.8s", webhooks.Sign(w.Secret, 0, nil)[len("sha256="):]),
+	}
+}
+
+// blockSnapshot describes a block for the audit log
+func blockSnapshot(roomID int, date string) map[string]interface{} {
+	return map[string]interface{}{
+		"room_id": roomID,
+		"date":    date,
+	}
+}
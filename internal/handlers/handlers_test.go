@@ -2,19 +2,26 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/driver"
+	"github.com/DungBuiTien1999/bookings/internal/events"
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/notify"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
 )
 
 var theTests = []struct {
@@ -29,6 +36,7 @@ var theTests = []struct {
 	{"majors_suite", "/majors-suite", "GET", http.StatusOK},
 	{"search_availability", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"contact about a reservation", "/contact?reservation=1", "GET", http.StatusOK},
 	{"non-exist", "/haha/hoho", "GET", http.StatusNotFound},
 	{"login", "/user/login", "GET", http.StatusOK},
 	{"logout", "/user/logout", "GET", http.StatusOK},
@@ -37,10 +45,26 @@ var theTests = []struct {
 	{"all reservations", "/admin/reservations-all", "GET", http.StatusOK},
 	{"show reservation", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"show reservation calender", "/admin/reservations-calendar?y=2021&m=10", "GET", http.StatusOK},
-	{"handle process mark with year", "/admin/process-reservation/new/1/do?y=2021&m=10", "GET", http.StatusOK},
-	{"handle process mark without year", "/admin/process-reservation/new/1/do", "GET", http.StatusOK},
-	{"handle delete reservation with year", "/admin/delete-reservation/new/1/do?y=2021&m=10", "GET", http.StatusOK},
-	{"handle delete reservation", "/admin/delete-reservation/new/1/do", "GET", http.StatusOK},
+	{"trash reservations", "/admin/reservations-trash", "GET", http.StatusOK},
+	{"mail outbox", "/admin/mail-outbox", "GET", http.StatusOK},
+	{"mail outbox by status", "/admin/mail-outbox?status=dead", "GET", http.StatusOK},
+	{"show outbox mail", "/admin/mail-outbox/1", "GET", http.StatusOK},
+	{"show missing outbox mail", "/admin/mail-outbox/2", "GET", http.StatusInternalServerError},
+	{"audit log", "/admin/audit", "GET", http.StatusOK},
+	{"audit log with filters", "/admin/audit?action=reservation.delete&entity_type=reservation&from=2021-10-01&to=2021-10-31", "GET", http.StatusOK},
+	{"audit log export", "/admin/audit/export?user_id=1", "GET", http.StatusOK},
+	{"email templates", "/admin/email-templates", "GET", http.StatusOK},
+	{"show default email template", "/admin/email-templates/confirmation", "GET", http.StatusOK},
+	{"show edited email template", "/admin/email-templates/owner-notification", "GET", http.StatusOK},
+	{"show unknown email template", "/admin/email-templates/invoice", "GET", http.StatusInternalServerError},
+	{"jobs", "/admin/jobs", "GET", http.StatusOK},
+	{"notifications", "/admin/notifications", "GET", http.StatusOK},
+	{"notification settings", "/admin/notifications/settings", "GET", http.StatusOK},
+	{"webhooks", "/admin/webhooks", "GET", http.StatusOK},
+	{"new webhook", "/admin/webhooks/new", "GET", http.StatusOK},
+	{"show webhook", "/admin/webhooks/1", "GET", http.StatusOK},
+	{"show staff webhook", "/admin/webhooks/3", "GET", http.StatusOK},
+	{"show missing webhook", "/admin/webhooks/4", "GET", http.StatusInternalServerError},
 }
 
 func TestHandlers(t *testing.T) {
@@ -61,6 +85,90 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+var adminActionTests = []struct {
+	name             string
+	url              string
+	year             string
+	month            string
+	expectedCode     int
+	expectedLocation string
+}{
+	{"process with year", "/admin/process-reservation/new/1/do", "2021", "10", http.StatusSeeOther, "/admin/reservations-calendar?y=2021&m=10"},
+	{"process without year", "/admin/process-reservation/new/1/do", "", "", http.StatusSeeOther, "/admin/reservations-new"},
+	{"delete with year", "/admin/delete-reservation/all/1/do", "2021", "10", http.StatusSeeOther, "/admin/reservations-calendar?y=2021&m=10"},
+	{"delete without year", "/admin/delete-reservation/all/1/do", "", "", http.StatusSeeOther, "/admin/reservations-all"},
+	{"delete in the trash", "/admin/delete-reservation/all/4/do", "", "", http.StatusSeeOther, "/admin/reservations-all"},
+	{"delete missing", "/admin/delete-reservation/all/5/do", "", "", http.StatusNotFound, ""},
+	{"restore", "/admin/restore-reservation/1/do", "", "", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"restore unavailable", "/admin/restore-reservation/2/do", "", "", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"restore failure", "/admin/restore-reservation/3/do", "", "", http.StatusInternalServerError, ""},
+	{"purge", "/admin/purge-reservation/4/do", "", "", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"purge active", "/admin/purge-reservation/1/do", "", "", http.StatusSeeOther, "/admin/reservations-trash"},
+	{"purge missing", "/admin/purge-reservation/5/do", "", "", http.StatusNotFound, ""},
+	{"resend mail", "/admin/mail-outbox/1/resend", "", "", http.StatusSeeOther, "/admin/mail-outbox"},
+	{"resend missing mail", "/admin/mail-outbox/2/resend", "", "", http.StatusInternalServerError, ""},
+	{"reset edited email template", "/admin/email-templates/owner-notification/reset", "", "", http.StatusSeeOther, "/admin/email-templates"},
+	{"reset default email template", "/admin/email-templates/confirmation/reset", "", "", http.StatusSeeOther, "/admin/email-templates"},
+	{"mark notifications read", "/admin/notifications/read", "", "", http.StatusSeeOther, "/admin/notifications"},
+	{"run job", "/admin/jobs/1/run", "", "", http.StatusSeeOther, "/admin/jobs"},
+	{"run missing job", "/admin/jobs/4/run", "", "", http.StatusInternalServerError, ""},
+	{"delete webhook", "/admin/webhooks/1/delete", "", "", http.StatusSeeOther, "/admin/webhooks"},
+	{"replay webhook delivery", "/admin/webhooks/deliveries/1/replay", "", "", http.StatusSeeOther, "/admin/webhooks/1"},
+	{"replay missing webhook delivery", "/admin/webhooks/deliveries/3/replay", "", "", http.StatusInternalServerError, ""},
+}
+
+func TestAdminActions(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	for _, e := range adminActionTests {
+		// state changing actions must never run from a link or an image tag
+		resp, err := client.Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("for GET %s, expected %d but got %d", e.name, http.StatusMethodNotAllowed, resp.StatusCode)
+		}
+
+		formData := url.Values{}
+		formData.Add("y", e.year)
+		formData.Add("m", e.month)
+		resp, err = client.PostForm(ts.URL+e.url, formData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != e.expectedCode {
+			t.Errorf("for POST %s, expected %d but got %d", e.name, e.expectedCode, resp.StatusCode)
+		}
+		if e.expectedLocation != "" && resp.Header.Get("Location") != e.expectedLocation {
+			t.Errorf("for POST %s, expected location %s but got %s", e.name, e.expectedLocation, resp.Header.Get("Location"))
+		}
+	}
+}
+
+func TestAdminActionsRequireCSRFToken(t *testing.T) {
+	routes := NoSurf(getRoutes())
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	for _, e := range adminActionTests {
+		resp, err := ts.Client().PostForm(ts.URL+e.url, url.Values{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("for POST %s without csrf token, expected %d but got %d", e.name, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
 func TestNewRepo(t *testing.T) {
 	var db driver.DB
 	testRepo := NewRepo(&app, &db)
@@ -705,6 +813,121 @@ func TestAdminPostShowReservation(t *testing.T) {
 	}
 }
 
+// mailRecorder keeps the mail the handlers queue
+type mailRecorder struct {
+	repository.DatabaseRepo
+	mail []models.MailData
+}
+
+func (m *mailRecorder) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return m
+}
+
+func (m *mailRecorder) EnqueueMail(mail models.MailData) error {
+	m.mail = append(m.mail, mail)
+	return nil
+}
+
+func (m *mailRecorder) InsertReservationWithMail(res models.Reservation, r models.RoomRestriction, mail func(id int) ([]models.MailData, error)) (int, error) {
+	return m.DatabaseRepo.InsertReservationWithMail(res, r, func(id int) ([]models.MailData, error) {
+		msgs, err := mail(id)
+		m.mail = append(m.mail, msgs...)
+		return msgs, err
+	})
+}
+
+func TestPostReservationMailsOwner(t *testing.T) {
+	for _, owner := range []string{"owner@here.com", ""} {
+		recorder := &mailRecorder{DatabaseRepo: Repo.DB}
+		notifier := notify.New(recorder, app.Logger)
+		notifier.Owner = owner
+		bus := events.NewBus(nil)
+		bus.Subscribe(notifier.Notify)
+		repo := &Repository{App: Repo.App, DB: recorder, Events: bus}
+
+		postData := url.Values{}
+		postData.Add("start_date", "2050-01-01")
+		postData.Add("end_date", "2050-01-03")
+		postData.Add("first_name", "dung")
+		postData.Add("last_name", "bui")
+		postData.Add("email", "dung@gmail.com")
+		postData.Add("phone", "023186753")
+		postData.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") != "" {
+			t.Fatalf("owner %q: expected the reservation to be made, got %d %q", owner, rr.Code, session.GetString(ctx, "error"))
+		}
+
+		// the guest's confirmation is queued with the reservation, then the subscribed staff member
+		// and the owner address are told by the notifier
+		var to []string
+		for _, msg := range recorder.mail {
+			to = append(to, msg.To)
+		}
+		expected := []string{"dung@gmail.com", "dung@gmail.com"}
+		if owner != "" {
+			expected = append(expected, owner)
+		}
+		if !reflect.DeepEqual(to, expected) {
+			t.Errorf("owner %q: expected mail to %v, got %v", owner, expected, to)
+		}
+	}
+}
+
+func TestAdminPostShowReservationEmailChange(t *testing.T) {
+	recorder := &mailRecorder{DatabaseRepo: Repo.DB}
+	repo := &Repository{App: Repo.App, DB: recorder, Events: Repo.Events}
+
+	formData := url.Values{}
+	formData.Add("first_name", "dung")
+	formData.Add("last_name", "bui")
+	formData.Add("email", "new@example.com")
+	formData.Add("phone", "320-334-8878")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations/all/6", strings.NewReader(formData.Encode()))
+	req.RequestURI = "/admin/reservations/all/6"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.AdminPostShowReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if len(recorder.mail) != 2 {
+		t.Fatalf("expected mail to the old and the new address, got %d", len(recorder.mail))
+	}
+
+	var tests = []struct {
+		to     string
+		method string
+	}{
+		{"old@example.com", "METHOD:CANCEL"},
+		{"new@example.com", "METHOD:REQUEST"},
+	}
+	for i, e := range tests {
+		msg := recorder.mail[i]
+		if msg.To != e.to || len(msg.Attachments) != 1 {
+			t.Errorf("expected mail %d to %s with an invite, got %s", i, e.to, msg.To)
+			continue
+		}
+		ics := string(msg.Attachments[0].Data)
+		if !strings.Contains(ics, e.method) || !strings.Contains(ics, "SEQUENCE:1") {
+			t.Errorf("expected mail %d to carry %s at the stored sequence", i, e.method)
+		}
+	}
+}
+
 func TestAdminPostCalendarReservations(t *testing.T) {
 	formData := url.Values{}
 	formData.Add("y", "2021")
@@ -742,6 +965,276 @@ func TestAdminPostCalendarReservations(t *testing.T) {
 	}
 }
 
+func TestAdminRestoreReservation(t *testing.T) {
+	// case room is still available
+	req, _ := http.NewRequest("POST", "/admin/restore-reservation/1/do", strings.NewReader(url.Values{}.Encode()))
+	req.RequestURI = "/admin/restore-reservation/1/do"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	var published []events.Event
+	bus := events.NewBus(nil)
+	bus.Subscribe(func(ctx context.Context, e events.Event) error {
+		published = append(published, e)
+		return nil
+	})
+	repo := &Repository{App: Repo.App, DB: Repo.DB, Events: bus}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(repo.AdminRestoreReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("failed restore: expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if session.GetString(ctx, "flash") != "Reservation restored" {
+		t.Error("failed restore: expected flash message")
+	}
+	if len(published) != 1 || published[0].Type != events.ReservationRestored || !published[0].Reservation.DeletedAt.IsZero() {
+		t.Errorf("failed restore: expected a reservation.restored event, got %+v", published)
+	}
+
+	// case room has been taken in the meantime
+	req, _ = http.NewRequest("POST", "/admin/restore-reservation/2/do", strings.NewReader(url.Values{}.Encode()))
+	req.RequestURI = "/admin/restore-reservation/2/do"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("failed restore unavailable: expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if session.GetString(ctx, "error") == "" {
+		t.Error("failed restore unavailable: expected error message")
+	}
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/admin/reservations-trash" {
+		t.Errorf("failed restore unavailable: expected location /admin/reservations-trash, but got %s", actualLoc.String())
+	}
+}
+
+func TestAdminPurgeReservation(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"in the trash", "4", "Reservation permanently deleted", ""},
+		{"active", "1", "", "Only reservations in the trash can be permanently deleted"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/purge-reservation/"+e.id+"/do", strings.NewReader(url.Values{}.Encode()))
+		req.RequestURI = "/admin/purge-reservation/" + e.id + "/do"
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPurgeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+func TestAdminDeleteReservationInTrash(t *testing.T) {
+	var published []events.Event
+	bus := events.NewBus(nil)
+	bus.Subscribe(func(ctx context.Context, e events.Event) error {
+		published = append(published, e)
+		return nil
+	})
+	recorder := &mailRecorder{DatabaseRepo: Repo.DB}
+	repo := &Repository{App: Repo.App, DB: recorder, Events: bus}
+
+	req, _ := http.NewRequest("POST", "/admin/delete-reservation/all/4/do", strings.NewReader(url.Values{}.Encode()))
+	req.RequestURI = "/admin/delete-reservation/all/4/do"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(repo.AdminDeleteReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if session.GetString(ctx, "error") != "Reservation is already in the trash" || session.GetString(ctx, "flash") != "" {
+		t.Errorf("expected an error message, got flash %q and error %q", session.GetString(ctx, "flash"), session.GetString(ctx, "error"))
+	}
+	if len(published) != 0 || len(recorder.mail) != 0 {
+		t.Errorf("expected no event nor mail for a reservation already in the trash, got %+v and %+v", published, recorder.mail)
+	}
+}
+
+func TestAdminAuditLogExport(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/audit/export", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminAuditLogExport)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminAuditLogExport handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if rr.Header().Get("Content-Type") != "text/csv" {
+		t.Errorf("expected text/csv content type, got %s", rr.Header().Get("Content-Type"))
+	}
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected header and 2 audit rows, got %d rows", len(records))
+	}
+	if records[2][10] != `'=HYPERLINK("http://evil.example")` {
+		t.Errorf("expected the formula to be quoted, got %s", records[2][10])
+	}
+}
+
+func TestAdminAuditLogExportLink(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/admin/audit?action=reservation.delete&entity_type=reservation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	m := regexp.MustCompile(`<a href="([^"]*)" class="btn btn-secondary mb-2">Export CSV</a>`).FindSubmatch(body)
+	if m == nil {
+		t.Fatal("expected an export link")
+	}
+	link := html.UnescapeString(string(m[1]))
+	if link != "/admin/audit/export?action=reservation.delete&entity_type=reservation" {
+		t.Errorf("expected the export link to carry the filters, got %s", link)
+	}
+
+	resp, err = ts.Client().Get(ts.URL + link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][4] != "reservation.delete" {
+		t.Errorf("expected only the filtered audit row, got %v", records)
+	}
+}
+
+func TestAdminPostEmailTemplate(t *testing.T) {
+	var tests = []struct {
+		name             string
+		subject          string
+		htmlBody         string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid", "Booked {{.RoomName}}", "<p>Dear {{.GuestName}}</p>", http.StatusSeeOther, "/admin/email-templates"},
+		{"missing subject", "", "<p>Dear {{.GuestName}}</p>", http.StatusOK, ""},
+		{"does not parse", "Booked", "<p>Dear {{.GuestName}</p>", http.StatusOK, ""},
+		{"unknown variable", "Booked", "<p>Dear {{.Guest}}</p>", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		formData := url.Values{}
+		formData.Add("subject", e.subject)
+		formData.Add("html_body", e.htmlBody)
+		formData.Add("text_body", "Dear {{.GuestName}}")
+
+		req, _ := http.NewRequest("POST", "/admin/email-templates/confirmation", strings.NewReader(formData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostEmailTemplate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected location %s, but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminPreviewEmailTemplate(t *testing.T) {
+	var tests = []struct {
+		name            string
+		url             string
+		subject         string
+		to              string
+		expectedOK      bool
+		expectedSubject string
+	}{
+		{"preview", "/admin/email-templates/confirmation/preview", "Booked {{.RoomName}}", "", true, "Booked General's Quarters"},
+		{"preview broken template", "/admin/email-templates/confirmation/preview", "Booked {{.RoomName", "", false, ""},
+		{"preview unknown template", "/admin/email-templates/invoice/preview", "Booked", "", false, ""},
+		{"test send", "/admin/email-templates/confirmation/test", "Booked {{.RoomName}}", "dung@gmail.com", true, "Booked General's Quarters"},
+		{"test send without address", "/admin/email-templates/confirmation/test", "Booked {{.RoomName}}", "", false, "Booked General's Quarters"},
+	}
+
+	for _, e := range tests {
+		formData := url.Values{}
+		formData.Add("subject", e.subject)
+		formData.Add("html_body", "<p>Dear {{.GuestName}}, manage your booking at {{.ManageLink}}</p>")
+		formData.Add("text_body", "Dear {{.GuestName}}")
+		formData.Add("to", e.to)
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(formData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPreviewEmailTemplate)
+		if strings.HasSuffix(e.url, "/test") {
+			handler = Repo.AdminTestEmailTemplate
+		}
+		handler.ServeHTTP(rr, req)
+
+		var j emailTemplatePreview
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Fatalf("for %s, failed to parse json: %s", e.name, err)
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("for %s, expected ok %t, but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+		if j.Subject != e.expectedSubject {
+			t.Errorf("for %s, expected subject %q, but got %q", e.name, e.expectedSubject, j.Subject)
+		}
+		if e.expectedOK && !strings.Contains(j.HTML, "John Smith") {
+			t.Errorf("for %s, expected preview against the sample reservation", e.name)
+		}
+	}
+}
+
 func getCtx(r *http.Request) context.Context {
 	ctx, err := session.Load(r.Context(), r.Header.Get("X-Session"))
 	if err != nil {
@@ -749,3 +1242,240 @@ func getCtx(r *http.Request) context.Context {
 	}
 	return ctx
 }
+
+func TestPostContact(t *testing.T) {
+	var tests = []struct {
+		name         string
+		email        string
+		reservation  string
+		message      string
+		expectedCode int
+	}{
+		{"valid", "ann@here.com", "", "Can I bring a dog?", http.StatusSeeOther},
+		{"valid about a reservation", "ann@here.com", "1", "Can I bring a dog?", http.StatusSeeOther},
+		{"missing message", "ann@here.com", "", "", http.StatusOK},
+		{"invalid email", "ann", "", "Can I bring a dog?", http.StatusOK},
+		{"invalid reservation", "ann@here.com", "one", "Can I bring a dog?", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		formData := url.Values{}
+		formData.Add("name", "Ann")
+		formData.Add("email", e.email)
+		formData.Add("reservation", e.reservation)
+		formData.Add("message", e.message)
+
+		req, _ := http.NewRequest("POST", "/contact", strings.NewReader(formData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostContact)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedCode == http.StatusSeeOther && rr.Header().Get("Location") != "/contact" {
+			t.Errorf("for %s, expected location /contact, but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminPostNotificationSettings(t *testing.T) {
+	var tests = []struct {
+		name             string
+		subscribe        []string
+		webhookURL       string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid", []string{"1:reservation.created:email", "1:reservation.created:webhook", "2:contact.message:feed"}, "https://hooks.here.com/bookings", http.StatusSeeOther, "/admin/notifications/settings"},
+		{"unchanged", []string{"1:reservation.created:email", "2:contact.message:feed"}, "http://localhost:8080/hooks", http.StatusSeeOther, "/admin/notifications/settings"},
+		{"invalid webhook address", []string{"1:reservation.created:email"}, "hooks.here.com", http.StatusOK, ""},
+		{"webhook without address", []string{"1:reservation.created:webhook"}, "", http.StatusOK, ""},
+		{"update failure", []string{"1:reservation.created:email"}, "http://fail.here.com", http.StatusInternalServerError, ""},
+	}
+
+	app.Features.Webhooks = true
+	defer func() { app.Features.Webhooks = false }()
+
+	for _, e := range tests {
+		formData := url.Values{}
+		for _, x := range e.subscribe {
+			formData.Add("subscribe", x)
+		}
+		formData.Add("webhook_url_1", e.webhookURL)
+
+		req, _ := http.NewRequest("POST", "/admin/notifications/settings", strings.NewReader(formData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNotificationSettings)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected location %s, but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestAdminPostNotificationSettingsWebhooksOff(t *testing.T) {
+	formData := url.Values{}
+	formData.Add("subscribe", "1:reservation.created:webhook")
+	formData.Add("webhook_url_1", "https://hooks.here.com/bookings")
+
+	req, _ := http.NewRequest("POST", "/admin/notifications/settings", strings.NewReader(formData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostNotificationSettings)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Webhooks are turned off") {
+		t.Errorf("expected the webhook channel to be refused with webhooks off, got code %d", rr.Code)
+	}
+}
+
+func TestAdminPostWebhook(t *testing.T) {
+	var tests = []struct {
+		name             string
+		url              string
+		webhookURL       string
+		events           []string
+		rotate           bool
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"new", "/admin/webhooks/new", "https://hooks.here.com/bookings", []string{"reservation.created", "block.created"}, false, http.StatusSeeOther, "/admin/webhooks/1"},
+		{"new with invalid address", "/admin/webhooks/new", "hooks.here.com", []string{"reservation.created"}, false, http.StatusOK, ""},
+		{"new without events", "/admin/webhooks/new", "https://hooks.here.com/bookings", nil, false, http.StatusOK, ""},
+		{"new with unknown event", "/admin/webhooks/new", "https://hooks.here.com/bookings", []string{"room.painted"}, false, http.StatusOK, ""},
+		{"update", "/admin/webhooks/1", "https://hooks.here.com/other", []string{"reservation.cancelled"}, true, http.StatusSeeOther, "/admin/webhooks/1"},
+		{"update with invalid address", "/admin/webhooks/1", "ftp://hooks.here.com", []string{"reservation.cancelled"}, false, http.StatusOK, ""},
+		{"update failure", "/admin/webhooks/2", "https://hooks.here.com/other", []string{"reservation.cancelled"}, false, http.StatusInternalServerError, ""},
+		{"update staff webhook without events", "/admin/webhooks/3", "https://hooks.here.com/staff", nil, false, http.StatusSeeOther, "/admin/webhooks/3"},
+		{"update missing webhook", "/admin/webhooks/4", "https://hooks.here.com/other", []string{"reservation.cancelled"}, false, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		formData := url.Values{}
+		formData.Add("url", e.webhookURL)
+		formData.Add("description", "Channel manager")
+		formData.Add("active", "1")
+		for _, x := range e.events {
+			formData.Add("events", x)
+		}
+		if e.rotate {
+			formData.Add("rotate_secret", "1")
+		}
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(formData.Encode()))
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostShowWebhook)
+		if strings.HasSuffix(e.url, "/new") {
+			handler = Repo.AdminPostNewWebhook
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected location %s, but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	var tests = []struct {
+		name         string
+		contentType  string
+		body         string
+		expectedCode int
+	}{
+		{"report-uri", "application/csp-report", `{"csp-report": {"blocked-uri": "inline", "effective-directive": "script-src-elem"}}`, http.StatusNoContent},
+		{"reporting api", "application/reports+json", `[{"type": "csp-violation", "body": {"blockedURL": "inline"}}]`, http.StatusNoContent},
+		{"malformed", "application/csp-report", `{`, http.StatusBadRequest},
+		{"too large", "application/csp-report", strings.Repeat(" ", maxCSPReportSize+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/csp-report", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.CSPReport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestTooManyRequests(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/search-availability", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	rr.Header().Set("Retry-After", "30")
+	handler := http.HandlerFunc(Repo.TooManyRequests)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests || !strings.Contains(rr.Body.String(), "wait 30 seconds") {
+		t.Errorf("expected the too many requests page, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	rr.Header().Set("Retry-After", "90")
+	handler = http.HandlerFunc(Repo.TooManyRequestsJSON)
+	handler.ServeHTTP(rr, req)
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusTooManyRequests || j.OK || !strings.Contains(j.Message, "a minute") {
+		t.Errorf("expected a failed search asking to wait a minute, got %d %+v", rr.Code, j)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/no-such-page", nil)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.NotFound)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "Page not found") {
+		t.Errorf("expected the 404 page, got %d", rr.Code)
+	}
+
+	req, _ = http.NewRequest("PUT", "/search-availability-json", nil)
+	req.Header.Set("Accept", "application/json")
+	req = req.WithContext(getCtx(req))
+
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.MethodNotAllowed)
+	handler.ServeHTTP(rr, req)
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusMethodNotAllowed || j.OK || j.Message != "Method Not Allowed" {
+		t.Errorf("expected a JSON error, got %d %+v", rr.Code, j)
+	}
+}
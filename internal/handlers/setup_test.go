@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,7 +13,10 @@ import (
 	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/helpers"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/notify"
 	"github.com/DungBuiTien1999/bookings/internal/render"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
@@ -27,6 +31,8 @@ var functions = template.FuncMap{
 	"humanDate":  render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
+	"asset":      render.Asset,
+	"liveReload": render.LiveReload,
 }
 var pathToTemplates = "../../templates"
 
@@ -41,11 +47,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -55,11 +57,6 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-	listenForMail()
-
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -72,18 +69,14 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	mailrender.SetTemplates(os.DirFS("../../email-templates"))
+	mailrender.NewMailrender(&app, repo.DB)
+	repo.Events.Subscribe(notify.New(repo.DB, app.Logger).Notify)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 
 	mux := chi.NewMux()
@@ -102,6 +95,7 @@ func getRoutes() http.Handler {
 	mux.Post("/search-availability-json", Repo.PostAvailabilityJSON)
 
 	mux.Get("/contact", Repo.Contact)
+	mux.Post("/contact", Repo.PostContact)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
@@ -116,12 +110,45 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminCalendarReservations)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostCalendarReservations)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Post("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
+	mux.Post("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Get("/admin/reservations-trash", Repo.AdminTrashReservations)
+	mux.Post("/admin/restore-reservation/{id}/do", Repo.AdminRestoreReservation)
+	mux.Post("/admin/purge-reservation/{id}/do", Repo.AdminPurgeReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/mail-outbox", Repo.AdminMailOutbox)
+	mux.Get("/admin/mail-outbox/{id}", Repo.AdminShowOutboxMail)
+	mux.Post("/admin/mail-outbox/{id}/resend", Repo.AdminResendOutboxMail)
+
+	mux.Get("/admin/email-templates", Repo.AdminEmailTemplates)
+	mux.Get("/admin/email-templates/{name}", Repo.AdminShowEmailTemplate)
+	mux.Post("/admin/email-templates/{name}", Repo.AdminPostEmailTemplate)
+	mux.Post("/admin/email-templates/{name}/preview", Repo.AdminPreviewEmailTemplate)
+	mux.Post("/admin/email-templates/{name}/test", Repo.AdminTestEmailTemplate)
+	mux.Post("/admin/email-templates/{name}/reset", Repo.AdminResetEmailTemplate)
+
+	mux.Get("/admin/notifications", Repo.AdminNotifications)
+	mux.Post("/admin/notifications/read", Repo.AdminMarkNotificationsRead)
+	mux.Get("/admin/notifications/settings", Repo.AdminNotificationSettings)
+	mux.Post("/admin/notifications/settings", Repo.AdminPostNotificationSettings)
+
+	mux.Get("/admin/webhooks", Repo.AdminWebhooks)
+	mux.Get("/admin/webhooks/new", Repo.AdminNewWebhook)
+	mux.Post("/admin/webhooks/new", Repo.AdminPostNewWebhook)
+	mux.Get("/admin/webhooks/{id}", Repo.AdminShowWebhook)
+	mux.Post("/admin/webhooks/{id}", Repo.AdminPostShowWebhook)
+	mux.Post("/admin/webhooks/{id}/delete", Repo.AdminDeleteWebhook)
+	mux.Post("/admin/webhooks/deliveries/{id}/replay", Repo.AdminReplayWebhookDelivery)
+
+	mux.Get("/admin/jobs", Repo.AdminJobs)
+	mux.Post("/admin/jobs/{id}/run", Repo.AdminRunJob)
+
+	mux.Get("/admin/audit", Repo.AdminAuditLog)
+	mux.Get("/admin/audit/export", Repo.AdminAuditLogExport)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -0,0 +1,120 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a component the application needs is working
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name     string
+	check    Check
+	critical bool
+}
+
+// Checker answers the liveness and readiness probes of the orchestrator
+type Checker struct {
+	// Timeout bounds each readiness check
+	Timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// New returns a checker without checks, giving each check timeout to answer
+func New(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout}
+}
+
+// Add reports check under name. Readiness depends on it when it is critical, a component that is not
+// critical is reported without failing the probe, the report is only degraded
+func (c *Checker) Add(name string, check Check, critical bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name, check, critical})
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the body of a probe response
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Run runs every check at once and reports whether the critical ones passed
+func (c *Checker) Run(ctx context.Context) (Report, bool) {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	report := Report{Status: "ok", Checks: make(map[string]Result, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, x := range checks {
+		wg.Add(1)
+		go func(x namedCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := x.check(ctx)
+			result := Result{Status: "ok", Critical: x.critical, Duration: time.Since(start).String()}
+			if err != nil {
+				result.Status = "error"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[x.name] = result
+			switch {
+			case err == nil:
+			case x.critical:
+				report.Status = "unavailable"
+			case report.Status == "ok":
+				report.Status = "degraded"
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	return report, report.Status != "unavailable"
+}
+
+// Live answers the liveness probe, the process is alive as long as it serves requests
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: "ok"})
+}
+
+// Ready answers the readiness probe with the result of every check, and 503 if a critical one failed
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report, ok := c.Run(r.Context())
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	out, _ := json.MarshalIndent(report, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(h http.HandlerFunc) (int, Report) {
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+	var report Report
+	_ = json.Unmarshal(rr.Body.Bytes(), &report)
+	return rr.Code, report
+}
+
+func TestReady(t *testing.T) {
+	c := New(50 * time.Millisecond)
+	c.Add("database", func(ctx context.Context) error { return nil }, true)
+	c.Add("templates", func(ctx context.Context) error { return nil }, true)
+
+	code, report := probe(c.Ready)
+	if code != http.StatusOK || report.Status != "ok" || len(report.Checks) != 2 || report.Checks["database"].Status != "ok" {
+		t.Errorf("expected ready, got %d %+v", code, report)
+	}
+
+	// a component that is not critical degrades the report without failing the probe
+	c.Add("smtp", func(ctx context.Context) error { return errors.New("connection refused") }, false)
+
+	code, report = probe(c.Ready)
+	if code != http.StatusOK || report.Status != "degraded" {
+		t.Errorf("expected degraded but ready, got %d %+v", code, report)
+	}
+	if report.Checks["smtp"].Error != "connection refused" || report.Checks["smtp"].Critical {
+		t.Errorf("expected the smtp failure to be reported as not critical, got %+v", report.Checks["smtp"])
+	}
+
+	c.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, true)
+
+	code, report = probe(c.Ready)
+	if code != http.StatusServiceUnavailable || report.Status != "unavailable" {
+		t.Errorf("expected unavailable, got %d %+v", code, report)
+	}
+	if report.Checks["smtp"].Error != "connection refused" || report.Checks["slow"].Status != "error" || report.Checks["database"].Status != "ok" {
+		t.Errorf("expected the detail of each component, got %+v", report.Checks)
+	}
+}
+
+func TestLive(t *testing.T) {
+	c := New(time.Second)
+	c.Add("database", func(ctx context.Context) error { return errors.New("down") }, true)
+
+	code, report := probe(c.Live)
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("expected liveness not to depend on the checks, got %d %+v", code, report)
+	}
+}
@@ -1,11 +1,13 @@
 package helpers
 
 import (
-	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/render"
 )
 
 var app *config.AppConfig
@@ -15,18 +17,29 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
-	http.Error(w, http.StatusText(status), status)
+// ClientError answers status with its error page, or a JSON error to API clients
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	logging.FromContext(r.Context()).Info("client error", "status", status)
+	render.Error(w, r, status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+// ServerError logs err with the stack that led to it and answers 500 with the error page, or a JSON
+// error to API clients
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	logging.FromContext(r.Context()).Error("server error", "error", err.Error(), "stack", string(debug.Stack()))
+	render.Error(w, r, http.StatusInternalServerError)
 }
 
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ClientIP returns the IP address of the client that made the request
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
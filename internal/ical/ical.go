@@ -0,0 +1,133 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// methods of an invite (RFC 5546). A request adds or updates the events in the attendee's
+// calendar, a cancel removes them
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// ContentType returns the MIME type of an invite sent with method
+func ContentType(method string) string {
+	return fmt.Sprintf("text/calendar; charset=UTF-8; method=%s", method)
+}
+
+// Event is an all day event of an invite. Calendars match updates and cancellations to the
+// event they already have by UID, so it must stay the same for the life of the event
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Date        time.Time
+}
+
+// Invite is an RFC 5545 calendar of events sent by an organizer to an attendee. Sequence must grow
+// with every invite sent about the same events, so calendars apply them in order
+type Invite struct {
+	ProdID       string
+	Method       string
+	Sequence     int
+	Stamp        time.Time
+	Organizer    string
+	AttendeeName string
+	Attendee     string
+	Events       []Event
+}
+
+// Bytes returns the invite as an iCalendar object
+func (i Invite) Bytes() []byte {
+	w := &writer{}
+
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + i.ProdID)
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:" + i.Method)
+
+	status := "CONFIRMED"
+	if i.Method == MethodCancel {
+		status = "CANCELLED"
+	}
+
+	for _, e := range i.Events {
+		w.line("BEGIN:VEVENT")
+		w.line("UID:" + e.UID)
+		w.line(fmt.Sprintf("SEQUENCE:%d", i.Sequence))
+		w.line("DTSTAMP:" + i.Stamp.UTC().Format("20060102T150405Z"))
+		w.line("DTSTART;VALUE=DATE:" + e.Date.Format("20060102"))
+		w.line("DTEND;VALUE=DATE:" + e.Date.AddDate(0, 0, 1).Format("20060102"))
+		w.line("SUMMARY:" + escape(e.Summary))
+		if e.Description != "" {
+			w.line("DESCRIPTION:" + escape(e.Description))
+		}
+		if e.Location != "" {
+			w.line("LOCATION:" + escape(e.Location))
+		}
+		if e.URL != "" {
+			w.line("URL:" + e.URL)
+		}
+		if i.Organizer != "" {
+			w.line("ORGANIZER:mailto:" + i.Organizer)
+		}
+		if i.Attendee != "" {
+			w.line(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;PARTSTAT=ACCEPTED:mailto:%s", param(i.AttendeeName), i.Attendee))
+		}
+		w.line("STATUS:" + status)
+		w.line("TRANSP:TRANSPARENT")
+		w.line("END:VEVENT")
+	}
+
+	w.line("END:VCALENDAR")
+
+	return w.buf.Bytes()
+}
+
+// writer writes content lines, folded at 75 octets and ended with CRLF as RFC 5545 requires
+type writer struct {
+	buf bytes.Buffer
+}
+
+func (w *writer) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		// never split a multi byte character over two lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space of a continuation line counts towards its length
+		limit = 74
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+// escape escapes a TEXT property value
+func escape(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// param quotes a property parameter value, which can't hold a double quote
+func param(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
@@ -0,0 +1,77 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInviteBytes(t *testing.T) {
+	invite := Invite{
+		ProdID:       "-//Test//Bookings//EN",
+		Method:       MethodRequest,
+		Sequence:     3,
+		Stamp:        time.Date(2021, 10, 18, 9, 30, 0, 0, time.UTC),
+		Organizer:    "bookings@example.com",
+		AttendeeName: `Dung "the guest" Bui`,
+		Attendee:     "dung@gmail.com",
+		Events: []Event{
+			{
+				UID:         "reservation-7-check-in@example.com",
+				Summary:     "Check-in, General's Quarters",
+				Description: "Reservation 7; see you soon,\nthe team " + strings.Repeat("é", 60),
+				Location:    "Fort Smythe",
+				URL:         "https://example.com/contact?reservation=7",
+				Date:        time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	out := string(invite.Bytes())
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"METHOD:REQUEST\r\n",
+		"UID:reservation-7-check-in@example.com\r\n",
+		"SEQUENCE:3\r\n",
+		"DTSTAMP:20211018T093000Z\r\n",
+		"DTSTART;VALUE=DATE:20500101\r\n",
+		"DTEND;VALUE=DATE:20500102\r\n",
+		`SUMMARY:Check-in\, General's Quarters`,
+		`DESCRIPTION:Reservation 7\; see you soon\,\nthe team`,
+		`ATTENDEE;CN="Dung 'the guest' Bui"`,
+		"STATUS:CONFIRMED\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected invite to contain %q", want)
+		}
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+		if strings.Contains(line, "\n") {
+			t.Errorf("line not ended with CRLF: %q", line)
+		}
+	}
+
+	// unfolding gives back the original line
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if !strings.Contains(unfolded, strings.Repeat("é", 60)) {
+		t.Error("folding split a multi byte character")
+	}
+
+	invite.Method = MethodCancel
+	out = string(invite.Bytes())
+	if !strings.Contains(out, "METHOD:CANCEL\r\n") || !strings.Contains(out, "STATUS:CANCELLED\r\n") {
+		t.Error("expected cancelled invite")
+	}
+}
+
+func TestContentType(t *testing.T) {
+	if got := ContentType(MethodCancel); got != "text/calendar; charset=UTF-8; method=CANCEL" {
+		t.Errorf("unexpected content type %q", got)
+	}
+}
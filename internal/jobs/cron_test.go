@@ -0,0 +1,64 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2021, 10, 20, 10, 17, 30, 0, time.UTC)
+
+	var tests = []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2021, 10, 20, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 10, 20, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 10, 20, 10, 25, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 10, 20, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 10, 21, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2021, 10, 21, 9, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2021, 10, 20, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1,5", time.Date(2021, 10, 22, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 10, 24, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// day of month and day of week both restricted: either matches
+		{"0 0 1 * 5", time.Date(2021, 10, 22, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		s, err := ParseSchedule(e.spec)
+		if err != nil {
+			t.Errorf("%s: %s", e.spec, err)
+			continue
+		}
+		if got, ok := s.Next(from); !ok || !got.Equal(e.expected) {
+			t.Errorf("%s: expected next run %s, got %s", e.spec, e.expected, got)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@yearly",
+		// never matches
+		"0 0 31 2 *",
+		"0 0 30 2 *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
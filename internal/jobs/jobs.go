@@ -0,0 +1,227 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+	"github.com/DungBuiTien1999/bookings/internal/worker"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Store is the part of the database repository the runner needs
+type Store interface {
+	RegisterRecurringJob(name, schedule string, runAt time.Time) error
+	EnqueueJob(j models.Job) (int, error)
+	ClaimJobs(limit int, lease time.Duration, owner string) ([]models.Job, error)
+	FinishJob(j models.Job, owner string) error
+}
+
+// Handler does the work of a job. It should return when ctx is cancelled, which happens when the
+// runner is stopped and the drain timeout has passed
+type Handler func(ctx context.Context, j models.Job) error
+
+// Runner runs recurring and one-off jobs kept in the database. Jobs are leased to the instance that
+// claims them, so with several instances running only one of them runs a given job, and a job whose
+// instance died is picked up again once its lease runs out. Failed jobs are retried with exponential
+// backoff; a one-off job is dead after MaxAttempts, a recurring one waits for its next run instead
+type Runner struct {
+	Store        Store
+	Owner        string
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Logger       *slog.Logger
+
+	handlers  map[string]Handler
+	schedules map[string]*Schedule
+
+	pool   *worker.Pool[models.Job]
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a runner with default settings. owner identifies this instance in job leases
+func New(store Store, owner string, logger *slog.Logger) *Runner {
+	return &Runner{
+		Store:        store,
+		Owner:        owner,
+		Workers:      2,
+		PollInterval: 10 * time.Second,
+		Lease:        10 * time.Minute,
+		MaxAttempts:  5,
+		BaseBackoff:  time.Minute,
+		MaxBackoff:   time.Hour,
+		Logger:       logger,
+		handlers:     make(map[string]Handler),
+		schedules:    make(map[string]*Schedule),
+	}
+}
+
+// Handle registers the handler of the name one-off job
+func (r *Runner) Handle(name string, h Handler) {
+	r.handlers[name] = h
+}
+
+// Every registers the name recurring job, run on the cron schedule spec
+func (r *Runner) Every(name, spec string, h Handler) error {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	r.handlers[name] = h
+	r.schedules[name] = s
+	return nil
+}
+
+// Enqueue schedules the name one-off job to run at runAt with payload. A non-empty key makes the job
+// unique: while a job with the same key exists no other is added and the returned id is 0
+func (r *Runner) Enqueue(name, key, payload string, runAt time.Time) (int, error) {
+	if _, ok := r.handlers[name]; !ok {
+		return 0, fmt.Errorf("no handler for job %q", name)
+	}
+	return r.Store.EnqueueJob(models.Job{
+		Name:    name,
+		Key:     key,
+		Payload: payload,
+		Status:  models.JobStatusScheduled,
+		RunAt:   runAt,
+	})
+}
+
+// Start records the recurring jobs in the database and starts the poller and the workers
+func (r *Runner) Start() error {
+	now := time.Now()
+	for name, s := range r.schedules {
+		next, ok := s.Next(now)
+		if !ok {
+			return fmt.Errorf("schedule %q of job %q never matches", s, name)
+		}
+		err := r.Store.RegisterRecurringJob(name, s.String(), next)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.pool = &worker.Pool[models.Job]{
+		Workers:      r.Workers,
+		PollInterval: r.PollInterval,
+		Claim: func() ([]models.Job, error) {
+			return r.Store.ClaimJobs(r.Workers, r.Lease, r.Owner)
+		},
+		Work:   r.run,
+		Logger: r.Logger.With("queue", "jobs"),
+	}
+	r.pool.Start()
+
+	return nil
+}
+
+// Stop stops claiming jobs and waits up to timeout for the running ones to finish, then cancels
+// their context and waits for them to return
+func (r *Runner) Stop(timeout time.Duration) {
+	r.pool.Close()
+
+	done := make(chan struct{})
+	go func() {
+		r.pool.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		r.Logger.Warn("jobs still running at shutdown, cancelling them", "timeout", timeout)
+		r.cancel()
+		<-done
+	}
+	r.cancel()
+}
+
+// run runs one job and records the outcome. A job queued by a request runs in its trace
+func (r *Runner) run(j models.Job) {
+	start := time.Now()
+	ctx, span := tracing.Start(tracing.Extract(r.ctx, j.TraceContext), "job "+j.Name,
+		attribute.Int("job.id", j.ID),
+		attribute.Int("job.attempt", j.Attempts+1),
+	)
+	err := r.call(ctx, j)
+	tracing.End(span, err)
+	now := time.Now()
+
+	j.LastRunAt = start
+	s, recurring := r.schedules[j.Name]
+
+	switch {
+	case err == nil:
+		j.Attempts = 0
+		j.LastError = ""
+		j.Status = models.JobStatusSucceeded
+		if recurring {
+			reschedule(&j, s, now)
+		}
+		r.Logger.Info("job finished", "job", j.Name, "job_id", j.ID, "duration", now.Sub(start).Round(time.Millisecond))
+	default:
+		j.Attempts++
+		j.LastError = err.Error()
+		j.Status = models.JobStatusScheduled
+		r.Logger.Error("job failed", "job", j.Name, "job_id", j.ID, "attempt", j.Attempts, "error", err)
+
+		switch {
+		case j.Attempts < r.MaxAttempts:
+			j.RunAt = now.Add(worker.Backoff(r.BaseBackoff, r.MaxBackoff, j.Attempts))
+		case recurring:
+			j.Attempts = 0
+			reschedule(&j, s, now)
+		default:
+			j.Status = models.JobStatusDead
+		}
+	}
+
+	err = r.Store.FinishJob(j, r.Owner)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the lease ran out while the job ran and another runner may have it now, so its outcome wins
+		r.Logger.Warn("job lease lost, outcome not recorded", "job", j.Name, "job_id", j.ID, "status", j.Status)
+	case err != nil:
+		r.Logger.Error("can't record job outcome", "job", j.Name, "job_id", j.ID, "error", err)
+	}
+}
+
+// reschedule sets the recurring job j to run at the next time of s after now, or to dead when s
+// doesn't match anymore
+func reschedule(j *models.Job, s *Schedule, now time.Time) {
+	next, ok := s.Next(now)
+	if !ok {
+		j.Status = models.JobStatusDead
+		j.LastError = fmt.Sprintf("schedule %q never matches again", s)
+		return
+	}
+	j.Status = models.JobStatusScheduled
+	j.RunAt = next
+}
+
+// call runs the handler of j, turning a panic into an error so one bad job can't stop the runner
+func (r *Runner) call(ctx context.Context, j models.Job) (err error) {
+	h, ok := r.handlers[j.Name]
+	if !ok {
+		return fmt.Errorf("no handler for job %q", j.Name)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+
+	return h(ctx, j)
+}
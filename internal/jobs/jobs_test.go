@@ -0,0 +1,246 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+type fakeStore struct {
+	mu        sync.Mutex
+	recurring map[string]time.Time
+	due       []models.Job
+	finished  map[int]models.Job
+	enqueued  []models.Job
+	owners    map[int]string
+}
+
+func newFakeStore(due ...models.Job) *fakeStore {
+	return &fakeStore{
+		recurring: make(map[string]time.Time),
+		due:       due,
+		finished:  make(map[int]models.Job),
+		owners:    make(map[int]string),
+	}
+}
+
+func (s *fakeStore) RegisterRecurringJob(name, schedule string, runAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recurring[name] = runAt
+	return nil
+}
+
+func (s *fakeStore) EnqueueJob(j models.Job) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enqueued = append(s.enqueued, j)
+	return len(s.enqueued), nil
+}
+
+func (s *fakeStore) ClaimJobs(limit int, lease time.Duration, owner string) ([]models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claimed := s.due
+	s.due = nil
+	for _, j := range claimed {
+		if _, ok := s.owners[j.ID]; !ok {
+			s.owners[j.ID] = owner
+		}
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) FinishJob(j models.Job, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[j.ID] != owner {
+		return sql.ErrNoRows
+	}
+	s.finished[j.ID] = j
+	return nil
+}
+
+func (s *fakeStore) result(id int) (models.Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.finished[id]
+	return j, ok
+}
+
+func (s *fakeStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.finished)
+}
+
+func newTestRunner(store Store) *Runner {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	r := New(store, "test", logger)
+	r.PollInterval = 10 * time.Millisecond
+	r.MaxAttempts = 3
+	return r
+}
+
+func TestRunner(t *testing.T) {
+	store := newFakeStore(
+		models.Job{ID: 1, Name: "ok"},
+		models.Job{ID: 2, Name: "fails", Attempts: 0},
+		models.Job{ID: 3, Name: "fails", Attempts: 2},
+		models.Job{ID: 4, Name: "recurring-fails", Attempts: 2},
+		models.Job{ID: 5, Name: "panics"},
+		models.Job{ID: 6, Name: "unknown"},
+		models.Job{ID: 7, Name: "recurring"},
+	)
+
+	r := newTestRunner(store)
+	r.Handle("ok", func(ctx context.Context, j models.Job) error { return nil })
+	r.Handle("fails", func(ctx context.Context, j models.Job) error { return errors.New("boom") })
+	r.Handle("panics", func(ctx context.Context, j models.Job) error { panic("boom") })
+	if err := r.Every("recurring", "@hourly", func(ctx context.Context, j models.Job) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Every("recurring-fails", "@daily", func(ctx context.Context, j models.Job) error { return errors.New("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Every("bad", "every now and then", nil); err == nil {
+		t.Error("expected error for bad schedule")
+	}
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if store.count() == 7 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r.Stop(time.Second)
+
+	if len(store.recurring) != 2 {
+		t.Errorf("expected 2 recurring jobs registered, got %d", len(store.recurring))
+	}
+
+	var tests = []struct {
+		id       int
+		status   string
+		attempts int
+	}{
+		{1, models.JobStatusSucceeded, 0},
+		{2, models.JobStatusScheduled, 1},
+		{3, models.JobStatusDead, 3},
+		{4, models.JobStatusScheduled, 0},
+		{5, models.JobStatusScheduled, 1},
+		{6, models.JobStatusScheduled, 1},
+		{7, models.JobStatusScheduled, 0},
+	}
+	for _, e := range tests {
+		j, ok := store.result(e.id)
+		if !ok {
+			t.Errorf("job %d did not run", e.id)
+			continue
+		}
+		if j.Status != e.status || j.Attempts != e.attempts {
+			t.Errorf("job %d: expected %s after %d attempts, got %s after %d", e.id, e.status, e.attempts, j.Status, j.Attempts)
+		}
+	}
+
+	// retries wait with backoff, recurring jobs wait for their next run
+	if j, _ := store.result(2); time.Until(j.RunAt) < 30*time.Second || j.LastError != "boom" {
+		t.Errorf("expected retry after backoff, got run at %s, error %q", j.RunAt, j.LastError)
+	}
+	if j, _ := store.result(7); j.RunAt.Minute() != 0 || !j.RunAt.After(time.Now()) {
+		t.Errorf("expected recurring job at the next hour, got %s", j.RunAt)
+	}
+	if j, _ := store.result(4); j.LastError != "boom" || !j.RunAt.After(time.Now()) || j.RunAt.Hour() != 0 || j.RunAt.Minute() != 0 {
+		t.Errorf("expected failed recurring job at its next run, got %s", j.RunAt)
+	}
+}
+
+func TestRunnerStopDrains(t *testing.T) {
+	store := newFakeStore(
+		models.Job{ID: 1, Name: "slow"},
+		models.Job{ID: 2, Name: "stuck"},
+	)
+
+	r := newTestRunner(store)
+	started := make(chan struct{}, 2)
+	r.Handle("slow", func(ctx context.Context, j models.Job) error {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	r.Handle("stuck", func(ctx context.Context, j models.Job) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	<-started
+
+	r.Stop(200 * time.Millisecond)
+
+	if j, _ := store.result(1); j.Status != models.JobStatusSucceeded {
+		t.Errorf("expected slow job to finish before stop returned, got %q", j.Status)
+	}
+	if j, ok := store.result(2); !ok || j.LastError == "" {
+		t.Error("expected stuck job to be cancelled and recorded as failed")
+	}
+}
+
+func TestRunnerLeaseLost(t *testing.T) {
+	store := newFakeStore(models.Job{ID: 1, Name: "slow"})
+	// another runner claimed the job after this one's lease ran out
+	store.owners[1] = "other"
+
+	r := newTestRunner(store)
+	done := make(chan struct{})
+	r.Handle("slow", func(ctx context.Context, j models.Job) error {
+		close(done)
+		return nil
+	})
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	r.Stop(time.Second)
+
+	if _, ok := store.result(1); ok {
+		t.Error("expected the outcome of a job whose lease was lost not to be recorded")
+	}
+}
+
+func TestRunnerEnqueue(t *testing.T) {
+	store := newFakeStore()
+	r := newTestRunner(store)
+	r.Handle("expire-hold", func(ctx context.Context, j models.Job) error { return nil })
+
+	at := time.Now().Add(time.Hour)
+	_, err := r.Enqueue("expire-hold", "hold-42", "42", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.enqueued) != 1 || store.enqueued[0].Key != "hold-42" || !store.enqueued[0].RunAt.Equal(at) {
+		t.Errorf("unexpected enqueued jobs %+v", store.enqueued)
+	}
+
+	_, err = r.Enqueue("missing", "", "", at)
+	if err == nil {
+		t.Error("expected error for job without handler")
+	}
+}
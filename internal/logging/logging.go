@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// RequestIDHeader carries the request ID, taken from the request when a proxy set it and always
+// sent back with the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client
+const maxRequestIDLength = 64
+
+// New returns a logger writing to w as text or json, dropping records below level
+// (debug, info, warn or error)
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type contextKey int
+
+const requestKey contextKey = 0
+
+// request is what the request logger keeps in the context of a request
+type request struct {
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithRequest returns ctx carrying logger, which should already hold the request ID, and room
+// for the fields handlers add with Annotate
+func WithRequest(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, requestKey, &request{logger: logger})
+}
+
+// FromContext returns the logger of the request ctx belongs to, or the default logger outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	req, ok := ctx.Value(requestKey).(*request)
+	if !ok {
+		return slog.Default()
+	}
+	return req.logger
+}
+
+// Annotate adds fields such as the user or reservation ID to the log line written when the request
+// completes. Outside a request it does nothing
+func Annotate(ctx context.Context, attrs ...slog.Attr) {
+	req, ok := ctx.Value(requestKey).(*request)
+	if !ok {
+		return
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.attrs = append(req.attrs, attrs...)
+}
+
+// Annotations returns the fields added with Annotate
+func Annotations(ctx context.Context) []slog.Attr {
+	req, ok := ctx.Value(requestKey).(*request)
+	if !ok {
+		return nil
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return append([]slog.Attr(nil), req.attrs...)
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether id, usually set by a proxy, is safe to log and send back
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	return strings.IndexFunc(id, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) < 0
+}
@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := New(&out, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped")
+	logger.Warn("kept", "reservation_id", 7)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json line, got %q", out.String())
+	}
+	if line["msg"] != "kept" || line["reservation_id"] != float64(7) {
+		t.Errorf("unexpected line %v", line)
+	}
+
+	out.Reset()
+	logger, _ = New(&out, "text", "debug")
+	logger.Debug("hello", "user_id", 1)
+	if !strings.Contains(out.String(), "level=DEBUG msg=hello user_id=1") {
+		t.Errorf("unexpected text line %q", out.String())
+	}
+
+	if _, err := New(&out, "xml", "info"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := New(&out, "text", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("expected the default logger outside a request")
+	}
+	Annotate(context.Background(), slog.Int("user_id", 1))
+
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, nil)).With("request_id", "abc")
+	ctx := WithRequest(context.Background(), logger)
+
+	FromContext(ctx).Info("inside")
+	if !strings.Contains(out.String(), "request_id=abc") {
+		t.Errorf("expected the request logger, got %q", out.String())
+	}
+
+	Annotate(ctx, slog.Int("user_id", 1))
+	Annotate(ctx, slog.Int("reservation_id", 7))
+	attrs := Annotations(ctx)
+	if len(attrs) != 2 || attrs[1].Key != "reservation_id" {
+		t.Errorf("unexpected annotations %v", attrs)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if len(a) != 16 || a == b || !ValidRequestID(a) {
+		t.Errorf("expected distinct valid ids, got %q and %q", a, b)
+	}
+
+	for id, valid := range map[string]bool{
+		"":                      false,
+		"f1e2d3c4-proxy_id.1":   true,
+		"has space":             false,
+		"line\nbreak":           false,
+		strings.Repeat("a", 65): false,
+		strings.Repeat("a", 64): true,
+	} {
+		if ValidRequestID(id) != valid {
+			t.Errorf("for %q expected %v", id, valid)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+// New returns the mailer for the transport configured in a
+func New(a *config.AppConfig) (Mailer, error) {
+	switch a.MailTransport {
+	case "smtp":
+		enc, err := encryption(a.SMTP.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		return &SMTPMailer{Config: a.SMTP, encryption: enc}, nil
+	case "file":
+		if a.MailDir == "" {
+			return nil, fmt.Errorf("file mail transport needs a directory")
+		}
+		return &FileMailer{Dir: a.MailDir, From: a.SMTP.From}, nil
+	case "log":
+		return &LogMailer{Log: a.Logger, From: a.SMTP.From}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", a.MailTransport)
+	}
+}
+
+// SMTPMailer sends messages through an SMTP relay
+type SMTPMailer struct {
+	Config     config.SMTPConfig
+	encryption mail.Encryption
+}
+
+// Send delivers m through the SMTP relay
+func (s *SMTPMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m, s.Config.From)
+	if err != nil {
+		return err
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = s.Config.Host
+	server.Port = s.Config.Port
+	server.Username = s.Config.Username
+	server.Password = s.Config.Password
+	server.Encryption = s.encryption
+	server.Authentication = mail.AuthNone
+	if s.Config.Username != "" {
+		server.Authentication = mail.AuthPlain
+	}
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	return email.Send(client)
+}
+
+// Ping checks the SMTP relay is reachable and greets us, without logging in or sending anything
+func (s *SMTPMailer) Ping(ctx context.Context) error {
+	addr := net.JoinHostPort(s.Config.Host, strconv.Itoa(s.Config.Port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if s.encryption == mail.EncryptionSSLTLS {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: s.Config.Host})
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	text := textproto.NewConn(conn)
+	_, _, err = text.ReadResponse(220)
+	if err != nil {
+		return fmt.Errorf("smtp relay %s: %w", addr, err)
+	}
+	_ = text.PrintfLine("QUIT")
+	return nil
+}
+
+// FileMailer writes every message as an .eml file into Dir instead of sending it
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes m to a new .eml file
+func (f *FileMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m, f.From)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(f.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), []byte(email.GetMessage()), 0644)
+}
+
+// LogMailer writes every message to a log instead of sending it
+type LogMailer struct {
+	Log  *slog.Logger
+	From string
+}
+
+// Send logs m
+func (l *LogMailer) Send(m models.MailData) error {
+	email, err := buildMessage(m, l.From)
+	if err != nil {
+		return err
+	}
+
+	l.Log.Info("email", "to", m.To, "subject", m.Subject, "message", email.GetMessage())
+	return nil
+}
+
+// buildMessage composes m, with a plain text alternative to the HTML content if it has one and
+// its attachments
+func buildMessage(m models.MailData, defaultFrom string) (*mail.Email, error) {
+	from := m.From
+	if from == "" {
+		from = defaultFrom
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(from).AddTo(m.To).SetSubject(m.Subject)
+	if m.PlainContent == "" {
+		email.SetBody(mail.TextHTML, m.Content)
+	} else {
+		// multipart/alternative, clients pick the last part they can show
+		email.SetBody(mail.TextPlain, m.PlainContent)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	}
+	for _, a := range m.Attachments {
+		email.Attach(&mail.File{Name: a.Filename, MimeType: a.ContentType, Data: a.Data})
+	}
+
+	if email.Error != nil {
+		return nil, email.Error
+	}
+	return email, nil
+}
+
+// encryption maps the configured encryption mode to the mail package's type
+func encryption(mode string) (mail.Encryption, error) {
+	switch mode {
+	case "", "none":
+		return mail.EncryptionNone, nil
+	case "ssl":
+		return mail.EncryptionSSLTLS, nil
+	case "starttls":
+		return mail.EncryptionSTARTTLS, nil
+	default:
+		return mail.EncryptionNone, fmt.Errorf("unknown smtp encryption %q", mode)
+	}
+}
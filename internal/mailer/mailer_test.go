@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name      string
+		app       config.AppConfig
+		expectErr bool
+	}{
+		{"smtp", config.AppConfig{MailTransport: "smtp", SMTP: config.SMTPConfig{Encryption: "starttls"}}, false},
+		{"smtp bad encryption", config.AppConfig{MailTransport: "smtp", SMTP: config.SMTPConfig{Encryption: "foo"}}, true},
+		{"file", config.AppConfig{MailTransport: "file", MailDir: t.TempDir()}, false},
+		{"file without dir", config.AppConfig{MailTransport: "file"}, true},
+		{"log", config.AppConfig{MailTransport: "log"}, false},
+		{"unknown", config.AppConfig{MailTransport: "pigeon"}, true},
+	}
+
+	for _, e := range tests {
+		_, err := New(&e.app)
+		if e.expectErr && err == nil {
+			t.Errorf("%s: expected error but got none", e.name)
+		}
+		if !e.expectErr && err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "default@here.com"}
+
+	err := m.Send(models.MailData{
+		To:           "guest@here.com",
+		Subject:      "Reservation Confirmation",
+		Content:      "<p>hello</p>",
+		PlainContent: "hello",
+		Attachments: []models.Attachment{
+			{Filename: "invite.ics", ContentType: "text/calendar; charset=UTF-8; method=REQUEST", Data: []byte("BEGIN:VCALENDAR")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	msg := string(data)
+	if !strings.Contains(msg, "Subject: Reservation Confirmation") {
+		t.Error("message does not have the subject")
+	}
+	if !strings.Contains(msg, "default@here.com") {
+		t.Error("message does not use the default from address")
+	}
+	if !strings.Contains(msg, "multipart/alternative") {
+		t.Error("message does not have a plain text alternative")
+	}
+	if !strings.Contains(msg, "multipart/mixed") || !strings.Contains(msg, `name="invite.ics"`) {
+		t.Error("message does not have the attachment")
+	}
+	if !strings.Contains(msg, "method=REQUEST") {
+		t.Error("attachment does not keep its content type")
+	}
+
+	err = m.Send(models.MailData{To: "not an address"})
+	if err == nil {
+		t.Error("expected error for invalid address")
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{Log: slog.New(slog.NewTextHandler(&buf, nil)), From: "default@here.com"}
+
+	err := m.Send(models.MailData{To: "guest@here.com", Subject: "Hi", Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "guest@here.com") {
+		t.Error("log does not have the recipient")
+	}
+}
+
+func TestSMTPMailerPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	greetings := []string{"220 mail.here.com ESMTP ready\r\n", "554 no service\r\n"}
+	go func() {
+		for _, greeting := range greetings {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(greeting))
+			_, _ = bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	m := &SMTPMailer{Config: config.SMTPConfig{Host: host, Port: portNumber}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := m.Ping(ctx); err != nil {
+		t.Errorf("expected the relay to answer, got %s", err)
+	}
+	if err := m.Ping(ctx); err == nil {
+		t.Error("expected an error when the relay refuses service")
+	}
+
+	l.Close()
+	if err := m.Ping(ctx); err == nil {
+		t.Error("expected an error when nothing listens")
+	}
+}
@@ -0,0 +1,349 @@
+package mailrender
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/ical"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// message types, each with a <name>.subject.tmpl, <name>.html.tmpl and <name>.txt.tmpl built-in
+// template that admins can override from the database
+const (
+	Confirmation        = "confirmation"
+	OwnerNotification   = "owner-notification"
+	OwnerChange         = "owner-change"
+	OwnerCancellation   = "owner-cancellation"
+	OwnerRestore        = "owner-restore"
+	OwnerContactMessage = "owner-contact-message"
+	Cancellation        = "cancellation"
+	Change              = "change"
+	Reminder            = "reminder"
+	CheckInInstructions = "check-in-instructions"
+	FollowUp            = "follow-up"
+)
+
+// Names lists the message types in the order they are shown to admins
+var Names = []string{
+	Confirmation, Change, Reminder, CheckInInstructions, FollowUp, Cancellation,
+	OwnerNotification, OwnerChange, OwnerCancellation, OwnerRestore, OwnerContactMessage,
+}
+
+// Variable is an entry of the palette of values the templates can use
+type Variable struct {
+	Code        string
+	Description string
+}
+
+// Variables is the palette shown next to the template editor
+var Variables = []Variable{
+	{"{{.GuestName}}", "Full name of the guest"},
+	{"{{.GuestEmail}}", "Email address of the guest"},
+	{"{{.RoomName}}", "Name of the booked room"},
+	{"{{formatDate .StartDate}}", "Arrival date"},
+	{"{{formatDate .EndDate}}", "Departure date"},
+	{"{{.ReservationID}}", "Reservation number"},
+	{"{{.ManageLink}}", "Link the guest follows to change or cancel the booking"},
+	{"{{.PropertyName}}", "Name of the property"},
+	{"{{.PropertyAddress}}", "Address of the property"},
+	{"{{.Message}}", "Message sent through the contact form"},
+}
+
+// Store loads the admin edited versions of the templates
+type Store interface {
+	GetEmailTemplate(name string) (models.EmailTemplate, error)
+}
+
+var app *config.AppConfig
+var store Store
+
+// templates holds the built-in email templates
+var templates fs.FS = os.DirFS("./email-templates")
+
+var functions = map[string]interface{}{
+	"formatDate": formatDate,
+}
+
+// NewMailrender sets the config and the store of edited templates for the package
+func NewMailrender(a *config.AppConfig, s Store) {
+	app = a
+	store = s
+}
+
+// ReservationData is the data available to the reservation message templates
+type ReservationData struct {
+	ReservationID   int
+	GuestName       string
+	GuestEmail      string
+	RoomName        string
+	StartDate       time.Time
+	EndDate         time.Time
+	ManageLink      string
+	PropertyName    string
+	PropertyAddress string
+	Message         string
+}
+
+// NewReservationData returns the template data describing res
+func NewReservationData(res models.Reservation) ReservationData {
+	data := ReservationData{
+		ReservationID: res.ID,
+		GuestName:     strings.TrimSpace(res.FirstName + " " + res.LastName),
+		GuestEmail:    res.Email,
+		RoomName:      res.Room.RoomName,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		ManageLink:    ManageLink(res.ID),
+	}
+	if app != nil {
+		data.PropertyName = app.PropertyName
+		data.PropertyAddress = app.PropertyAddress
+	}
+	return data
+}
+
+// NewContactData returns the template data describing a message sent through the contact form. The
+// sender takes the place of the guest
+func NewContactData(msg models.ContactMessage) ReservationData {
+	data := ReservationData{
+		ReservationID: msg.ReservationID,
+		GuestName:     msg.Name,
+		GuestEmail:    msg.Email,
+		Message:       msg.Message,
+	}
+	if app != nil {
+		data.PropertyName = app.PropertyName
+		data.PropertyAddress = app.PropertyAddress
+	}
+	return data
+}
+
+// SampleData returns the reservation used to preview and validate templates
+func SampleData() ReservationData {
+	start := time.Now().AddDate(0, 0, 7)
+	data := NewReservationData(models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(start.Year(), start.Month(), start.Day()+2, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	})
+	data.Message = "Could we check in a little earlier than usual?"
+	return data
+}
+
+// ManageLink returns the link guests follow to change or cancel reservation id. There is no self
+// service page yet, so it takes them to the contact page with the reservation number filled in
+func ManageLink(id int) string {
+	baseURL := ""
+	if app != nil {
+		baseURL = strings.TrimSuffix(app.BaseURL, "/")
+	}
+	return fmt.Sprintf("%s/contact?reservation=%d", baseURL, id)
+}
+
+// SetTemplates sets the files the built-in email templates are read from
+func SetTemplates(fsys fs.FS) {
+	templates = fsys
+}
+
+// Default returns the built-in template of the name message type
+func Default(name string) (models.EmailTemplate, error) {
+	t := models.EmailTemplate{Name: name}
+
+	parts := []struct {
+		ext  string
+		dest *string
+	}{
+		{"subject.tmpl", &t.Subject},
+		{"html.tmpl", &t.HTMLBody},
+		{"txt.tmpl", &t.TextBody},
+	}
+	for _, p := range parts {
+		b, err := fs.ReadFile(templates, name+"."+p.ext)
+		if err != nil {
+			return t, err
+		}
+		*p.dest = string(b)
+	}
+	t.Subject = strings.TrimSpace(t.Subject)
+
+	return t, nil
+}
+
+// Render renders the name message type with data into a message to the given address. The version
+// edited by admins is used when there is one and it renders, otherwise the built-in one is
+func Render(name, to string, data interface{}) (models.MailData, error) {
+	def, err := Default(name)
+	if err != nil {
+		return models.MailData{To: to}, err
+	}
+
+	if store != nil {
+		t, err := store.GetEmailTemplate(name)
+		if err == nil {
+			msg, err := Execute(t, to, data)
+			if err == nil {
+				return msg, nil
+			}
+			logger().Error("email template does not render, using the built-in one", "template", name, "error", err)
+		}
+	}
+
+	return Execute(def, to, data)
+}
+
+// Execute renders t with data into a message to the given address. The HTML body is wrapped in
+// the shared layout and escaped by html/template, the plain text body is wrapped in the text layout
+func Execute(t models.EmailTemplate, to string, data interface{}) (models.MailData, error) {
+	msg := models.MailData{To: to}
+
+	st, err := texttemplate.New("subject").Funcs(functions).Parse(t.Subject)
+	if err != nil {
+		return msg, err
+	}
+
+	ht, err := htmltemplate.New("layout.html.tmpl").Funcs(functions).ParseFS(templates, "layout.html.tmpl")
+	if err != nil {
+		return msg, err
+	}
+	_, err = ht.New("content").Parse(t.HTMLBody)
+	if err != nil {
+		return msg, err
+	}
+
+	tt, err := texttemplate.New("layout.txt.tmpl").Funcs(functions).ParseFS(templates, "layout.txt.tmpl")
+	if err != nil {
+		return msg, err
+	}
+	_, err = tt.New("content").Parse(t.TextBody)
+	if err != nil {
+		return msg, err
+	}
+
+	buf := new(bytes.Buffer)
+	err = st.Execute(buf, data)
+	if err != nil {
+		return msg, err
+	}
+	msg.Subject = strings.Join(strings.Fields(buf.String()), " ")
+
+	buf.Reset()
+	err = tt.ExecuteTemplate(buf, "layout.txt.tmpl", data)
+	if err != nil {
+		return msg, err
+	}
+	msg.PlainContent = buf.String()
+
+	buf.Reset()
+	err = ht.ExecuteTemplate(buf, "layout.html.tmpl", data)
+	if err != nil {
+		return msg, err
+	}
+	msg.Content = buf.String()
+
+	return msg, nil
+}
+
+// Validate checks that t parses and renders against the sample reservation
+func Validate(t models.EmailTemplate) error {
+	_, err := Execute(t, "", SampleData())
+	return err
+}
+
+// ReservationMail renders the name message type about res to the given address
+func ReservationMail(name, to string, res models.Reservation) (models.MailData, error) {
+	return Render(name, to, NewReservationData(res))
+}
+
+// ContactMail renders the name message type about msg to the given address
+func ContactMail(name, to string, msg models.ContactMessage) (models.MailData, error) {
+	return Render(name, to, NewContactData(msg))
+}
+
+// ReservationInvite returns a calendar invite with the check-in and check-out of res, to attach to
+// mail about it. The events keep the same UID for the life of the reservation, so an invite sent
+// later with ical.MethodRequest updates them and one sent with ical.MethodCancel removes them, as
+// long as it carries a higher res.InviteSequence
+func ReservationInvite(method string, res models.Reservation) models.Attachment {
+	data := NewReservationData(res)
+
+	property := "Bookings"
+	location := ""
+	organizer := ""
+	host := "localhost"
+	if app != nil {
+		if app.PropertyName != "" {
+			property = app.PropertyName
+		}
+		location = app.PropertyAddress
+		organizer = app.SMTP.From
+		if u, err := url.Parse(app.BaseURL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+	}
+	if location == "" {
+		location = property
+	}
+
+	description := fmt.Sprintf("Reservation %d, %s at %s.\nChange or cancel your booking: %s",
+		data.ReservationID, data.RoomName, property, data.ManageLink)
+
+	invite := ical.Invite{
+		ProdID:       fmt.Sprintf("-//%s//Bookings//EN", property),
+		Method:       method,
+		Sequence:     res.InviteSequence,
+		Stamp:        time.Now(),
+		Organizer:    organizer,
+		AttendeeName: data.GuestName,
+		Attendee:     data.GuestEmail,
+		Events: []ical.Event{
+			{
+				UID:         fmt.Sprintf("reservation-%d-check-in@%s", data.ReservationID, host),
+				Summary:     fmt.Sprintf("Check-in: %s, %s", data.RoomName, property),
+				Description: description,
+				Location:    location,
+				URL:         data.ManageLink,
+				Date:        data.StartDate,
+			},
+			{
+				UID:         fmt.Sprintf("reservation-%d-check-out@%s", data.ReservationID, host),
+				Summary:     fmt.Sprintf("Check-out: %s, %s", data.RoomName, property),
+				Description: description,
+				Location:    location,
+				URL:         data.ManageLink,
+				Date:        data.EndDate,
+			},
+		},
+	}
+
+	return models.Attachment{
+		Filename:    "invite.ics",
+		ContentType: ical.ContentType(method),
+		Data:        invite.Bytes(),
+	}
+}
+
+// formatDate formats dates in mail as YYYY-MM-DD
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func logger() *slog.Logger {
+	if app != nil && app.Logger != nil {
+		return app.Logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,197 @@
+package mailrender
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/ical"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+func TestRender(t *testing.T) {
+	templates = os.DirFS("../../email-templates")
+
+	res := models.Reservation{
+		FirstName: "<script>alert(1)</script>",
+		LastName:  "bui",
+		Email:     "dung@gmail.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	for _, name := range []string{Confirmation, OwnerNotification, Cancellation} {
+		msg, err := ReservationMail(name, res.Email, res)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if msg.To != res.Email {
+			t.Errorf("%s: expected to %s, got %s", name, res.Email, msg.To)
+		}
+		if msg.Subject == "" || strings.Contains(msg.Subject, "\n") {
+			t.Errorf("%s: bad subject %q", name, msg.Subject)
+		}
+		if strings.Contains(msg.Content, "<script>") {
+			t.Errorf("%s: guest name is not escaped in html part", name)
+		}
+		if !strings.Contains(msg.Content, "&lt;script&gt;") {
+			t.Errorf("%s: html part does not have the guest name", name)
+		}
+		if !strings.Contains(msg.PlainContent, "2050-01-01") {
+			t.Errorf("%s: plain text part does not have the start date", name)
+		}
+	}
+
+	_, err := ReservationMail("missing", res.Email, res)
+	if err == nil {
+		t.Error("expected error for missing message type")
+	}
+}
+
+type fakeStore map[string]models.EmailTemplate
+
+func (s fakeStore) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	t, ok := s[name]
+	if !ok {
+		return t, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+func TestRenderEditedTemplate(t *testing.T) {
+	templates = os.DirFS("../../email-templates")
+	store = fakeStore{
+		Confirmation: {
+			Name:     Confirmation,
+			Subject:  "Your stay in {{.RoomName}}",
+			HTMLBody: "<p>Hi {{.GuestName}}, manage it at {{.ManageLink}}</p>",
+			TextBody: "Hi {{.GuestName}}",
+		},
+		Cancellation: {
+			Name:     Cancellation,
+			Subject:  "Cancelled",
+			HTMLBody: "{{.Missing}",
+			TextBody: "Bye",
+		},
+	}
+	defer func() { store = nil }()
+
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "dung",
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	msg, err := ReservationMail(Confirmation, "dung@gmail.com", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Your stay in General's Quarters" {
+		t.Errorf("edited subject not used, got %q", msg.Subject)
+	}
+	if !strings.Contains(msg.Content, "/contact?reservation=7") {
+		t.Error("html part does not have the manage link")
+	}
+	if !strings.HasPrefix(msg.PlainContent, "Hi dung") {
+		t.Errorf("edited text body not used, got %q", msg.PlainContent)
+	}
+
+	msg, err = ReservationMail(Cancellation, "dung@gmail.com", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Reservation Cancelled" {
+		t.Errorf("expected fallback to built-in template for broken edit, got subject %q", msg.Subject)
+	}
+
+	msg, err = ReservationMail(OwnerNotification, "owner@gmail.com", res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Reservation Notification" {
+		t.Errorf("expected built-in template without an edit, got subject %q", msg.Subject)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	templates = os.DirFS("../../email-templates")
+
+	for _, name := range Names {
+		def, err := Default(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := Validate(def); err != nil {
+			t.Errorf("%s: built-in template does not validate: %s", name, err)
+		}
+	}
+
+	bad := []models.EmailTemplate{
+		{Subject: "{{.GuestName", HTMLBody: "ok", TextBody: "ok"},
+		{Subject: "ok", HTMLBody: "{{.NoSuchField}}", TextBody: "ok"},
+		{Subject: "ok", HTMLBody: "ok", TextBody: "{{unknownFunc .GuestName}}"},
+	}
+	for i, tpl := range bad {
+		if err := Validate(tpl); err == nil {
+			t.Errorf("case %d: expected validation error", i)
+		}
+	}
+}
+
+func TestReservationInvite(t *testing.T) {
+	app = &config.AppConfig{
+		BaseURL:         "https://bookings.example.com",
+		PropertyName:    "Fort Smythe Bed and Breakfast",
+		PropertyAddress: "1 Fort Road, Smythe",
+		SMTP:            config.SMTPConfig{From: "bookings@example.com"},
+	}
+	defer func() { app = nil }()
+
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "dung",
+		LastName:  "bui",
+		Email:     "dung@gmail.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+
+		InviteSequence: 2,
+	}
+
+	request := ReservationInvite(ical.MethodRequest, res)
+	if request.Filename != "invite.ics" || !strings.Contains(request.ContentType, "method=REQUEST") {
+		t.Errorf("unexpected attachment %s (%s)", request.Filename, request.ContentType)
+	}
+
+	ics := strings.ReplaceAll(string(request.Data), "\r\n ", "")
+	for _, want := range []string{
+		"UID:reservation-7-check-in@bookings.example.com",
+		"UID:reservation-7-check-out@bookings.example.com",
+		"DTSTART;VALUE=DATE:20500101",
+		"DTSTART;VALUE=DATE:20500103",
+		`LOCATION:1 Fort Road\, Smythe`,
+		"https://bookings.example.com/contact?reservation=7",
+		"ORGANIZER:mailto:bookings@example.com",
+		"mailto:dung@gmail.com",
+		"SEQUENCE:2",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("expected invite to contain %q", want)
+		}
+	}
+
+	cancel := ReservationInvite(ical.MethodCancel, res)
+	ics = string(cancel.Data)
+	if !strings.Contains(ics, "METHOD:CANCEL") {
+		t.Error("expected a cancelled invite")
+	}
+	if !strings.Contains(ics, "UID:reservation-7-check-in@bookings.example.com") {
+		t.Error("cancelled invite does not keep the UID of the events")
+	}
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bookings"
+
+// search kinds
+const (
+	SearchAllRooms = "all_rooms"
+	SearchRoom     = "room"
+)
+
+// Registry holds the application metrics along with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer HTTP requests by method and route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database repository methods.",
+		// queries time out after 3 seconds
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+	}, []string{"method"})
+
+	// MailSent and MailFailures count delivery attempts of the mail outbox
+	MailSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mail_sent_total",
+		Help:      "Messages delivered from the mail outbox.",
+	})
+	MailFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mail_failures_total",
+		Help:      "Failed attempts to deliver messages from the mail outbox.",
+	})
+
+	searches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "searches_total",
+		Help:      "Availability searches, for all rooms or a single room.",
+	}, []string{"kind"})
+	searchesWithoutAvailability = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "searches_without_availability_total",
+		Help:      "Availability searches that found nothing available.",
+	}, []string{"kind"})
+
+	// ReservationsCreated counts reservations made by guests
+	ReservationsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reservations_created_total",
+		Help:      "Reservations made by guests.",
+	})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests turned away by the rate limit of their route group.",
+	}, []string{"group"})
+
+	sessionsMu    sync.RWMutex
+	sessionsCount func() (int, error)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		dbQueryDuration,
+		MailSent,
+		MailFailures,
+		searches,
+		searchesWithoutAvailability,
+		ReservationsCreated,
+		rateLimited,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "sessions_active",
+			Help:      "Sessions that have not expired.",
+		}, activeSessions),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an HTTP request answered with status after taking duration. route is the
+// route pattern, so that requests for different ids share their series
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObserveQuery records the time taken by the repository method name since start
+func ObserveQuery(name string, start time.Time) {
+	dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// Search counts an availability search of the given kind and whether it found anything
+func Search(kind string, available bool) {
+	searches.WithLabelValues(kind).Inc()
+	if !available {
+		searchesWithoutAvailability.WithLabelValues(kind).Inc()
+	}
+}
+
+// RateLimited counts a request over the rate limit of group
+func RateLimited(group string) {
+	rateLimited.WithLabelValues(group).Inc()
+}
+
+// CountSessions sets the function the active sessions gauge is read from
+func CountSessions(count func() (int, error)) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	sessionsCount = count
+}
+
+func activeSessions() float64 {
+	sessionsMu.RLock()
+	count := sessionsCount
+	sessionsMu.RUnlock()
+
+	if count == nil {
+		return 0
+	}
+	n, err := count()
+	if err != nil {
+		return 0
+	}
+	return float64(n)
+}
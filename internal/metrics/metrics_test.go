@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	ObserveRequest("GET", "/admin/reservations/{src}/{id}/show", 200, 20*time.Millisecond)
+	ObserveQuery("AllRooms", time.Now().Add(-5*time.Millisecond))
+	Search(SearchAllRooms, true)
+	Search(SearchRoom, false)
+	ReservationsCreated.Inc()
+	CountSessions(func() (int, error) { return 3, nil })
+
+	if got := testutil.ToFloat64(searchesWithoutAvailability.WithLabelValues(SearchRoom)); got != 1 {
+		t.Errorf("expected one search without availability, got %v", got)
+	}
+	if got := testutil.ToFloat64(searchesWithoutAvailability.WithLabelValues(SearchAllRooms)); got != 0 {
+		t.Errorf("expected a search with availability not to count, got %v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rr.Body)
+
+	for _, want := range []string{
+		`bookings_http_requests_total{method="GET",route="/admin/reservations/{src}/{id}/show",status="200"} 1`,
+		`bookings_db_query_duration_seconds_count{method="AllRooms"} 1`,
+		`bookings_searches_total{kind="all_rooms"} 1`,
+		`bookings_reservations_created_total 1`,
+		`bookings_sessions_active 3`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %s in\n%s", want, body)
+		}
+	}
+}
@@ -12,8 +12,12 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// WebhookID and WebhookURL are the webhook the staff member's notifications are posted to,
+	// zero and empty when there is none
+	WebhookID  int
+	WebhookURL string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 // Room is the room model
@@ -45,7 +49,10 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Processed int
+	DeletedAt time.Time
 	Room      Room
+	// InviteSequence grows with every calendar invite sent about the reservation
+	InviteSequence int
 }
 
 // RoomRestriction is the roomRestriction model
@@ -65,9 +72,194 @@ type RoomRestriction struct {
 
 // MailData holds email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To           string
+	From         string
+	Subject      string
+	Content      string
+	PlainContent string
+	Attachments  []Attachment
+	// TraceContext is the traceparent of the request the message was queued by, kept with it in
+	// the outbox so its delivery joins that trace
+	TraceContext string
+}
+
+// Attachment is a file attached to an email message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// statuses of a message in the mail outbox
+const (
+	MailStatusPending = "pending"
+	MailStatusSending = "sending"
+	MailStatusSent    = "sent"
+	MailStatusDead    = "dead"
+)
+
+// OutboxMail is a message waiting in, or delivered from, the mail outbox
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// AuditLog is the audit log model
+type AuditLog struct {
+	ID         int
+	UserID     int
+	Action     string
+	EntityType string
+	EntityID   int
+	Before     string
+	After      string
+	Diff       string
+	IPAddress  string
+	CreatedAt  time.Time
+	User       User
+}
+
+// AuditFilter holds the criteria used to search the audit log
+type AuditFilter struct {
+	UserID     int
+	Action     string
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+}
+
+// EmailTemplate is an admin edited version of one of the transactional mail templates
+type EmailTemplate struct {
+	ID        int
+	Name      string
+	Subject   string
+	HTMLBody  string
+	TextBody  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Notification records that a scheduled message was sent to the guest of a reservation
+type Notification struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	CreatedAt     time.Time
+}
+
+// statuses of a background job
+const (
+	JobStatusScheduled = "scheduled"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusDead      = "dead"
+)
+
+// Job is a recurring or one-off background job. Recurring jobs have a cron Schedule and are
+// scheduled again after every run, one-off jobs run once at RunAt
+type Job struct {
+	ID          int
+	Name        string
+	Key         string
+	Schedule    string
+	Payload     string
+	Status      string
+	Attempts    int
+	RunAt       time.Time
+	LockedBy    string
+	LockedUntil time.Time
+	LastRunAt   time.Time
+	LastError   string
+	// TraceContext is the traceparent of the request the job was queued by
+	TraceContext string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// channels staff notifications are delivered on
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelFeed    = "feed"
+)
+
+// NotificationSubscription is a staff member's choice to be told about an event on a channel
+type NotificationSubscription struct {
+	ID        int
+	UserID    int
+	EventType string
+	Channel   string
+	CreatedAt time.Time
+	User      User
+}
+
+// FeedItem is an entry of a staff member's in-app notification feed
+type FeedItem struct {
+	ID        int
+	UserID    int
+	EventType string
+	Title     string
+	Body      string
+	Link      string
+	ReadAt    time.Time
+	CreatedAt time.Time
+}
+
+// ContactMessage is a message sent through the contact form
+type ContactMessage struct {
+	Name          string
+	Email         string
+	ReservationID int
+	Message       string
+}
+
+// Webhook is an endpoint events are posted to, signed with its secret
+type Webhook struct {
+	ID          int
+	URL         string
+	Description string
+	Secret      string
+	Events      []string
+	Active      bool
+	// UserID is the staff member whose notifications the webhook posts, zero for the webhooks
+	// subscribed to events from the webhooks page
+	UserID    int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// statuses of a webhook delivery
+const (
+	WebhookStatusPending   = "pending"
+	WebhookStatusSending   = "sending"
+	WebhookStatusDelivered = "delivered"
+	WebhookStatusDead      = "dead"
+)
+
+// WebhookDelivery is an event posted, or waiting to be posted, to a webhook
+type WebhookDelivery struct {
+	ID            int
+	WebhookID     int
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	ResponseCode  int
+	ResponseBody  string
+	LastError     string
+	DeliveredAt   time.Time
+	// TraceContext is the traceparent of the request that published the event
+	TraceContext string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Webhook      Webhook
 }
@@ -14,4 +14,9 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// CSPNonce is the nonce inline scripts are tagged with, so the Content-Security-Policy runs them
+	CSPNonce string
+	// LiveReload is the URL the layouts poll to refresh the page when its templates change, empty
+	// unless template reloading is on
+	LiveReload string
 }
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/DungBuiTien1999/bookings/internal/events"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+)
+
+// Store is the part of the database repository the notifier needs
+type Store interface {
+	SubscriptionsForEvent(eventType string) ([]models.NotificationSubscription, error)
+	EnqueueMail(m models.MailData) error
+	InsertFeedItem(f models.FeedItem) error
+}
+
+// messages maps each event type to the mail message type staff receive for it
+var messages = map[string]string{
+	events.ReservationCreated:   mailrender.OwnerNotification,
+	events.ReservationUpdated:   mailrender.OwnerChange,
+	events.ReservationCancelled: mailrender.OwnerCancellation,
+	events.ReservationRestored:  mailrender.OwnerRestore,
+	events.ContactMessage:       mailrender.OwnerContactMessage,
+}
+
+// Sender queues an event for a single webhook, signed and retried like every webhook delivery. It
+// is implemented by the webhook dispatcher
+type Sender interface {
+	Send(ctx context.Context, e events.Event, hook int) error
+}
+
+// Notifier tells staff about events on the channels they subscribed to. Nothing is delivered while
+// the event is published: mail goes into the outbox, webhook calls into the delivery log of the
+// staff member's webhook, and feed entries are stored for the admin pages
+type Notifier struct {
+	Store Store
+	// Webhooks queues the webhook notifications, nil while webhooks are turned off
+	Webhooks Sender
+	// Owner is mailed every new booking on top of the subscriptions, unless an email subscription
+	// already sends it there. Empty mails nobody
+	Owner  string
+	Logger *slog.Logger
+}
+
+// New returns a notifier with webhook notifications turned off
+func New(store Store, logger *slog.Logger) *Notifier {
+	return &Notifier{
+		Store:  store,
+		Logger: logger,
+	}
+}
+
+// Notify is the events.Subscriber notifying the staff subscribed to e. A subscription that can't be
+// served is logged and the others are still served
+func (n *Notifier) Notify(ctx context.Context, e events.Event) error {
+	subscriptions, err := n.Store.SubscriptionsForEvent(e.Type)
+	if err != nil {
+		return err
+	}
+
+	owner := e.Type == events.ReservationCreated && n.Owner != ""
+	for _, s := range subscriptions {
+		if s.Channel == models.ChannelEmail && strings.EqualFold(s.User.Email, n.Owner) {
+			owner = false
+		}
+		err := n.deliver(ctx, e, s)
+		if err != nil {
+			n.Logger.Error("can't notify staff", "user_id", s.UserID, "event", e.Type, "channel", s.Channel,
+				"reservation_id", e.Reservation.ID, "error", err)
+		}
+	}
+
+	if owner {
+		err := n.mail(ctx, e, n.Owner)
+		if err != nil {
+			n.Logger.Error("can't notify owner", "event", e.Type, "reservation_id", e.Reservation.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// mail queues the message telling staff about e to the given address, in the trace of ctx
+func (n *Notifier) mail(ctx context.Context, e events.Event, to string) error {
+	msg, err := Mail(e, to)
+	if err != nil {
+		return err
+	}
+	msg.TraceContext = tracing.Inject(ctx)
+	return n.Store.EnqueueMail(msg)
+}
+
+// deliver queues the notification of e for subscription s, in the trace of ctx
+func (n *Notifier) deliver(ctx context.Context, e events.Event, s models.NotificationSubscription) error {
+	switch s.Channel {
+	case models.ChannelEmail:
+		return n.mail(ctx, e, s.User.Email)
+
+	case models.ChannelWebhook:
+		if n.Webhooks == nil {
+			return errors.New("webhooks are turned off")
+		}
+		if s.User.WebhookID == 0 {
+			return errors.New("no active webhook address set")
+		}
+		return n.Webhooks.Send(ctx, e, s.User.WebhookID)
+
+	case models.ChannelFeed:
+		item := Describe(e)
+		item.UserID = s.UserID
+		return n.Store.InsertFeedItem(item)
+	}
+
+	return fmt.Errorf("unknown channel %q", s.Channel)
+}
+
+// Mail renders the message telling staff about e, to the given address
+func Mail(e events.Event, to string) (models.MailData, error) {
+	name, ok := messages[e.Type]
+	if !ok {
+		return models.MailData{To: to}, fmt.Errorf("no message for event %s", e.Type)
+	}
+	if e.Type == events.ContactMessage {
+		return mailrender.ContactMail(name, to, e.Message)
+	}
+	return mailrender.ReservationMail(name, to, e.Reservation)
+}
+
+// Describe returns the feed entry telling staff about e, without its user
+func Describe(e events.Event) models.FeedItem {
+	item := models.FeedItem{EventType: e.Type}
+
+	res := e.Reservation
+	guest := strings.TrimSpace(res.FirstName + " " + res.LastName)
+	stay := fmt.Sprintf("%s, %s to %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+	link := fmt.Sprintf("/admin/reservations/all/%d/show", res.ID)
+
+	switch e.Type {
+	case events.ReservationCreated:
+		item.Title, item.Body, item.Link = "New booking from "+guest, stay, link
+	case events.ReservationUpdated:
+		item.Title, item.Body, item.Link = "Booking of "+guest+" changed", stay, link
+	case events.ReservationCancelled:
+		// a cancelled reservation is in the trash and has no page of its own
+		item.Title, item.Body, item.Link = "Booking of "+guest+" cancelled", stay, "/admin/reservations-trash"
+	case events.ReservationRestored:
+		item.Title, item.Body, item.Link = "Booking of "+guest+" restored", stay, link
+	case events.ContactMessage:
+		item.Title = "Message from " + e.Message.Name + " (" + e.Message.Email + ")"
+		item.Body = e.Message.Message
+		if e.Message.ReservationID > 0 {
+			item.Title += fmt.Sprintf(" about reservation %d", e.Message.ReservationID)
+		}
+	default:
+		item.Title = e.Type
+	}
+
+	return item
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/events"
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+type fakeStore struct {
+	subscriptions []models.NotificationSubscription
+	mail          []models.MailData
+	feed          []models.FeedItem
+}
+
+// fakeSender records the webhook deliveries queued, by webhook id
+type fakeSender struct {
+	sent map[int][]events.Event
+}
+
+func (s *fakeSender) Send(ctx context.Context, e events.Event, hook int) error {
+	if s.sent == nil {
+		s.sent = make(map[int][]events.Event)
+	}
+	s.sent[hook] = append(s.sent[hook], e)
+	return nil
+}
+
+func (s *fakeStore) SubscriptionsForEvent(eventType string) ([]models.NotificationSubscription, error) {
+	var found []models.NotificationSubscription
+	for _, x := range s.subscriptions {
+		if x.EventType == eventType {
+			found = append(found, x)
+		}
+	}
+	return found, nil
+}
+
+func (s *fakeStore) EnqueueMail(m models.MailData) error {
+	s.mail = append(s.mail, m)
+	return nil
+}
+
+func (s *fakeStore) InsertFeedItem(f models.FeedItem) error {
+	s.feed = append(s.feed, f)
+	return nil
+}
+
+func newTestNotifier(store Store) *Notifier {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return New(store, logger)
+}
+
+func TestNotify(t *testing.T) {
+	mailrender.SetTemplates(os.DirFS("../../email-templates"))
+
+	owner := models.User{ID: 1, Email: "owner@here.com", WebhookID: 4, WebhookURL: "http://hooks.here.com/owner"}
+	clerk := models.User{ID: 2, Email: "clerk@here.com"}
+	store := &fakeStore{
+		subscriptions: []models.NotificationSubscription{
+			{UserID: 1, EventType: events.ReservationCreated, Channel: models.ChannelEmail, User: owner},
+			{UserID: 1, EventType: events.ReservationCreated, Channel: models.ChannelWebhook, User: owner},
+			{UserID: 2, EventType: events.ReservationCreated, Channel: models.ChannelFeed, User: clerk},
+			// no webhook address, skipped
+			{UserID: 2, EventType: events.ReservationCreated, Channel: models.ChannelWebhook, User: clerk},
+			{UserID: 2, EventType: events.ContactMessage, Channel: models.ChannelEmail, User: clerk},
+		},
+	}
+	sender := &fakeSender{}
+	n := newTestNotifier(store)
+	n.Webhooks = sender
+
+	err := n.Notify(context.Background(), events.Event{
+		Type: events.ReservationCreated,
+		Reservation: models.Reservation{
+			ID:        7,
+			FirstName: "John",
+			LastName:  "Smith",
+			StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC),
+			Room:      models.Room{RoomName: "Major's Suite"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.mail) != 1 || store.mail[0].To != "owner@here.com" || !strings.Contains(store.mail[0].PlainContent, "Major's Suite") {
+		t.Errorf("expected the owner to be mailed, got %+v", store.mail)
+	}
+	if len(sender.sent) != 1 || len(sender.sent[4]) != 1 || sender.sent[4][0].Reservation.ID != 7 {
+		t.Errorf("expected one delivery to the owner's webhook, got %+v", sender.sent)
+	}
+	if len(store.feed) != 1 || store.feed[0].UserID != 2 || store.feed[0].Link != "/admin/reservations/all/7/show" {
+		t.Errorf("expected one feed entry for the clerk, got %+v", store.feed)
+	}
+
+	err = n.Notify(context.Background(), events.Event{
+		Type:    events.ContactMessage,
+		Message: models.ContactMessage{Name: "Ann", Email: "ann@here.com", ReservationID: 7, Message: "Can I bring a dog?"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.mail) != 2 || store.mail[1].To != "clerk@here.com" || !strings.Contains(store.mail[1].PlainContent, "Can I bring a dog?") {
+		t.Errorf("expected the clerk to get the contact message, got %+v", store.mail)
+	}
+
+	// the owner address is mailed new bookings once, even when it is subscribed to them too
+	n.Owner = "Owner@here.com"
+	err = n.Notify(context.Background(), events.Event{Type: events.ReservationCreated, Reservation: models.Reservation{ID: 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.mail) != 3 || store.mail[2].To != "owner@here.com" {
+		t.Errorf("expected one mail to the owner, got %+v", store.mail[2:])
+	}
+
+	n.Owner = "manager@here.com"
+	err = n.Notify(context.Background(), events.Event{Type: events.ReservationCreated, Reservation: models.Reservation{ID: 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.mail) != 5 || store.mail[4].To != "manager@here.com" {
+		t.Errorf("expected the owner address to be mailed the new booking, got %+v", store.mail[3:])
+	}
+
+	// other events are only sent to the subscriptions
+	err = n.Notify(context.Background(), events.Event{Type: events.ReservationCancelled, Reservation: models.Reservation{ID: 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range store.mail[5:] {
+		if msg.To == "manager@here.com" {
+			t.Errorf("expected the owner address to get new bookings only, got %+v", msg)
+		}
+	}
+}
+
+func TestNotifyWebhooksOff(t *testing.T) {
+	store := &fakeStore{
+		subscriptions: []models.NotificationSubscription{
+			{UserID: 1, EventType: events.ReservationCancelled, Channel: models.ChannelWebhook, User: models.User{WebhookID: 4}},
+			{UserID: 1, EventType: events.ReservationCancelled, Channel: models.ChannelFeed},
+		},
+	}
+	n := newTestNotifier(store)
+
+	// the webhook subscription can't be served without the dispatcher, the others still are
+	err := n.Notify(context.Background(), events.Event{Type: events.ReservationCancelled, Reservation: models.Reservation{ID: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.feed) != 1 {
+		t.Errorf("expected the feed entry despite webhooks being off, got %+v", store.feed)
+	}
+}
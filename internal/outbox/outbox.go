@@ -0,0 +1,101 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/mailer"
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+	"github.com/DungBuiTien1999/bookings/internal/worker"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Store is the part of the database repository the dispatcher needs
+type Store interface {
+	ClaimOutboxMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkOutboxMailSent(id int) error
+	MarkOutboxMailFailed(id int, lastError string, nextAttempt time.Time, dead bool) error
+}
+
+// Dispatcher delivers mail from the outbox with a pool of workers, retrying failures with
+// exponential backoff until MaxAttempts is reached and the message becomes dead
+type Dispatcher struct {
+	Store        Store
+	Mailer       mailer.Mailer
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Logger       *slog.Logger
+
+	pool *worker.Pool[models.OutboxMail]
+}
+
+// New returns a dispatcher with default settings
+func New(store Store, m mailer.Mailer, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Mailer:       m,
+		Workers:      2,
+		PollInterval: 5 * time.Second,
+		Lease:        5 * time.Minute,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		Logger:       logger,
+	}
+}
+
+// Start starts the poller and the workers
+func (d *Dispatcher) Start() {
+	d.pool = &worker.Pool[models.OutboxMail]{
+		Workers:      d.Workers,
+		PollInterval: d.PollInterval,
+		Claim: func() ([]models.OutboxMail, error) {
+			return d.Store.ClaimOutboxMail(d.Workers*5, d.Lease)
+		},
+		Work:   d.deliver,
+		Logger: d.Logger.With("queue", "outbox"),
+	}
+	d.pool.Start()
+}
+
+// Stop stops polling and waits for the workers to finish the messages they hold
+func (d *Dispatcher) Stop() {
+	d.pool.Stop()
+}
+
+// deliver sends one message and records the outcome, in the trace of the request that queued it
+func (d *Dispatcher) deliver(x models.OutboxMail) {
+	_, span := tracing.Start(tracing.Extract(context.Background(), x.Mail.TraceContext), "mail.send",
+		attribute.Int("outbox.id", x.ID),
+		attribute.Int("outbox.attempt", x.Attempts+1),
+	)
+	err := d.Mailer.Send(x.Mail)
+	tracing.End(span, err)
+	if err == nil {
+		metrics.MailSent.Inc()
+		err = d.Store.MarkOutboxMailSent(x.ID)
+		if err != nil {
+			d.Logger.Error("can't mark email sent", "outbox_id", x.ID, "error", err)
+			return
+		}
+		d.Logger.Info("email sent", "outbox_id", x.ID, "to", x.Mail.To, "attempt", x.Attempts+1)
+		return
+	}
+
+	metrics.MailFailures.Inc()
+	attempts := x.Attempts + 1
+	dead := attempts >= d.MaxAttempts
+	d.Logger.Error("email failed", "outbox_id", x.ID, "to", x.Mail.To, "attempt", attempts, "dead", dead, "error", err)
+
+	err = d.Store.MarkOutboxMailFailed(x.ID, err.Error(), time.Now().Add(worker.Backoff(d.BaseBackoff, d.MaxBackoff, attempts)), dead)
+	if err != nil {
+		d.Logger.Error("can't mark email failed", "outbox_id", x.ID, "error", err)
+	}
+}
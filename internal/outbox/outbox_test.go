@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+type fakeStore struct {
+	mu     sync.Mutex
+	due    []models.OutboxMail
+	sent   []int
+	failed map[int]bool
+}
+
+func (s *fakeStore) ClaimOutboxMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mail := s.due
+	s.due = nil
+	return mail, nil
+}
+
+func (s *fakeStore) MarkOutboxMailSent(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, id)
+	return nil
+}
+
+func (s *fakeStore) MarkOutboxMailFailed(id int, lastError string, nextAttempt time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[id] = dead
+	return nil
+}
+
+type fakeMailer struct{}
+
+func (m *fakeMailer) Send(mail models.MailData) error {
+	if mail.To == "broken@here.com" {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	store := &fakeStore{
+		due: []models.OutboxMail{
+			{ID: 1, Mail: models.MailData{To: "guest@here.com"}},
+			{ID: 2, Mail: models.MailData{To: "broken@here.com"}, Attempts: 0},
+			{ID: 3, Mail: models.MailData{To: "broken@here.com"}, Attempts: 7},
+		},
+		failed: make(map[int]bool),
+	}
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	d := New(store, &fakeMailer{}, discard)
+	d.PollInterval = 10 * time.Millisecond
+	d.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		store.mu.Lock()
+		done := len(store.sent)+len(store.failed) == 3
+		store.mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Stop()
+
+	if len(store.sent) != 1 || store.sent[0] != 1 {
+		t.Errorf("expected message 1 to be sent, got %v", store.sent)
+	}
+	if dead, ok := store.failed[2]; !ok || dead {
+		t.Error("expected message 2 to be retried")
+	}
+	if dead, ok := store.failed[3]; !ok || !dead {
+		t.Error("expected message 3 to be dead after its last attempt")
+	}
+}
@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/logging"
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
+)
+
+// Limit allows Requests every Per, in bursts of up to Requests. The zero Limit allows everything
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses a limit written as requests/period, such as 30/1m or 5/s. An empty string or
+// off is the zero Limit
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" {
+		return Limit{}, nil
+	}
+
+	n, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 30/1m", s)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || requests < 1 {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 30/1m, the requests must be a whole number above zero", s)
+	}
+	per = strings.TrimSpace(per)
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("%q is not a limit such as 30/1m, the period must be a duration above zero", s)
+	}
+	return Limit{Requests: requests, Per: d}, nil
+}
+
+// Off reports whether the limit allows everything
+func (l Limit) Off() bool {
+	return l.Requests <= 0 || l.Per <= 0
+}
+
+// String returns the limit the way ParseLimit reads it
+func (l Limit) String() string {
+	if l.Off() {
+		return "off"
+	}
+	return strconv.Itoa(l.Requests) + "/" + l.Per.String()
+}
+
+// Store keeps a token bucket for each key
+type Store interface {
+	// Take takes a token from the bucket of key, which holds limit.Requests tokens and is refilled
+	// at limit, reporting whether there was one and, when there was not, how long until there is
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// bucket is a token bucket as of its last update
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is full again, after which it can be forgotten
+	full time.Time
+}
+
+// sweepInterval is how often the memory store forgets the buckets that filled up again
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in memory, so with several instances each one limits on its own
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	if limit.Off() {
+		return true, 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.swept) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Per.Seconds()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((capacity - b.tokens) / rate * float64(time.Second)))
+
+	if allowed {
+		return true, 0, nil
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+}
+
+// Middleware limits the requests of each client, told apart by key, to the routes of group. Requests
+// over limit get a Retry-After header and are answered by exceeded. When the store fails the request
+// is let through
+func Middleware(store Store, group string, limit Limit, key func(*http.Request) string, exceeded http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Off() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter, err := store.Take(r.Context(), group+":"+key(r), limit)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("can't check rate limit", "group", group, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !allowed {
+				metrics.RateLimited(group)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				exceeded.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
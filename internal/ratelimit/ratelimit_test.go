@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	var tests = []struct {
+		limit    string
+		expected Limit
+		valid    bool
+	}{
+		{"30/1m", Limit{30, time.Minute}, true},
+		{"5/s", Limit{5, time.Second}, true},
+		{" 100 / 1h ", Limit{100, time.Hour}, true},
+		{"", Limit{}, true},
+		{"off", Limit{}, true},
+		{"30", Limit{}, false},
+		{"0/1m", Limit{}, false},
+		{"30/fortnight", Limit{}, false},
+		{"30/-1m", Limit{}, false},
+	}
+
+	for _, e := range tests {
+		l, err := ParseLimit(e.limit)
+		if (err == nil) != e.valid || l != e.expected {
+			t.Errorf("for %q, expected %v (valid %t), got %v, %v", e.limit, e.expected, e.valid, l, err)
+		}
+	}
+
+	if (Limit{30, time.Minute}).String() != "30/1m0s" || (Limit{}).String() != "off" {
+		t.Error("expected the limit to print the way it is parsed")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2021, 10, 20, 9, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Per: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := s.Take(ctx, "ip:10.0.0.1", limit); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, retryAfter, _ := s.Take(ctx, "ip:10.0.0.1", limit)
+	if ok || retryAfter != 20*time.Second {
+		t.Errorf("expected the fourth request to wait 20s, got %t, %s", ok, retryAfter)
+	}
+	if ok, _, _ := s.Take(ctx, "ip:10.0.0.2", limit); !ok {
+		t.Error("expected another client to have its own bucket")
+	}
+
+	// a token comes back every 20 seconds
+	now = now.Add(20 * time.Second)
+	if ok, _, _ := s.Take(ctx, "ip:10.0.0.1", limit); !ok {
+		t.Error("expected a request once a token came back")
+	}
+
+	// buckets that filled up again are forgotten
+	now = now.Add(2 * time.Minute)
+	_, _, _ = s.Take(ctx, "ip:10.0.0.3", limit)
+	if len(s.buckets) != 1 {
+		t.Errorf("expected the full buckets to be swept, %d left", len(s.buckets))
+	}
+
+	if ok, _, _ := s.Take(ctx, "ip:10.0.0.1", Limit{}); !ok {
+		t.Error("expected no limit to allow everything")
+	}
+}
+
+// failingStore fails every Take
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	exceeded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	key := func(r *http.Request) string { return r.RemoteAddr }
+
+	h := Middleware(NewMemoryStore(), "login", Limit{Requests: 1, Per: time.Minute}, key, exceeded)(next)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/user/login", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the first request to be served, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/user/login", nil))
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	h = Middleware(failingStore{}, "login", Limit{Requests: 1, Per: time.Minute}, key, exceeded)(next)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/user/login", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the request to be let through when the store fails, got %d", rr.Code)
+	}
+
+	if Middleware(failingStore{}, "login", Limit{}, key, exceeded)(next) == nil {
+		t.Error("expected a handler without a limit")
+	}
+}
@@ -0,0 +1,98 @@
+package reminders
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// Store is the part of the database repository the scheduler needs
+type Store interface {
+	ReservationsArrivingBetween(start, end time.Time) ([]models.Reservation, error)
+	ReservationsDepartingBetween(start, end time.Time) ([]models.Reservation, error)
+	InsertNotificationWithMail(reservationID int, kind string, mail models.MailData) (bool, error)
+}
+
+// Schedule sends the Name message type Days before arrival, or Days after departure when AfterStay
+// is set. A schedule with zero days is turned off
+type Schedule struct {
+	Name      string
+	Days      int
+	AfterStay bool
+}
+
+// Scheduler queues scheduled guest mail for the reservations that are due for it. Every message is
+// recorded against its reservation in the same transaction as it is queued, so running again, even
+// after a restart, never sends a message twice
+type Scheduler struct {
+	Store     Store
+	Schedules []Schedule
+	// CatchUp is how many days late a post-stay message may still go out, so a scheduler that was
+	// not running on the day it was due sends it once it is back
+	CatchUp int
+	Logger  *slog.Logger
+}
+
+// New returns a scheduler with default settings
+func New(store Store, schedules []Schedule, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		Store:     store,
+		Schedules: schedules,
+		CatchUp:   7,
+		Logger:    logger,
+	}
+}
+
+// Run queues every message that is due on the day of now and returns how many were queued. Pre-arrival
+// messages go to reservations arriving from today up to Days from now, so a booking made inside
+// that window still gets them; post-stay messages go to reservations that left Days ago, or up to
+// CatchUp days before that. A failure on one reservation is logged and does not stop the others
+func (s *Scheduler) Run(now time.Time) (int, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sent := 0
+
+	for _, sch := range s.Schedules {
+		if sch.Days <= 0 {
+			continue
+		}
+
+		var reservations []models.Reservation
+		var err error
+		if sch.AfterStay {
+			end := today.AddDate(0, 0, -sch.Days)
+			reservations, err = s.Store.ReservationsDepartingBetween(end.AddDate(0, 0, -s.CatchUp), end)
+		} else {
+			reservations, err = s.Store.ReservationsArrivingBetween(today, today.AddDate(0, 0, sch.Days))
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		for _, res := range reservations {
+			ok, err := s.send(sch.Name, res)
+			if err != nil {
+				s.Logger.Error("can't queue scheduled email", "message", sch.Name, "reservation_id", res.ID, "error", err)
+				continue
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+
+	if sent > 0 {
+		s.Logger.Info("queued scheduled emails", "count", sent)
+	}
+	return sent, nil
+}
+
+// send queues the name message for res unless it has been sent before
+func (s *Scheduler) send(name string, res models.Reservation) (bool, error) {
+	msg, err := mailrender.ReservationMail(name, res.Email, res)
+	if err != nil {
+		return false, err
+	}
+	return s.Store.InsertNotificationWithMail(res.ID, name, msg)
+}
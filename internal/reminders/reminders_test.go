@@ -0,0 +1,109 @@
+package reminders
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/mailrender"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// fakeStore keeps reservations in memory and remembers the messages sent for them
+type fakeStore struct {
+	reservations []models.Reservation
+	sent         map[int]map[string]bool
+	queued       []models.MailData
+}
+
+func (s *fakeStore) between(start, end time.Time, date func(models.Reservation) time.Time) []models.Reservation {
+	var found []models.Reservation
+	for _, r := range s.reservations {
+		d := date(r)
+		if !d.Before(start) && !d.After(end) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func (s *fakeStore) ReservationsArrivingBetween(start, end time.Time) ([]models.Reservation, error) {
+	return s.between(start, end, func(r models.Reservation) time.Time { return r.StartDate }), nil
+}
+
+func (s *fakeStore) ReservationsDepartingBetween(start, end time.Time) ([]models.Reservation, error) {
+	return s.between(start, end, func(r models.Reservation) time.Time { return r.EndDate }), nil
+}
+
+func (s *fakeStore) InsertNotificationWithMail(reservationID int, kind string, mail models.MailData) (bool, error) {
+	if s.sent[reservationID] == nil {
+		s.sent[reservationID] = map[string]bool{}
+	}
+	if s.sent[reservationID][kind] {
+		return false, nil
+	}
+	s.sent[reservationID][kind] = true
+	s.queued = append(s.queued, mail)
+	return true, nil
+}
+
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	mailrender.SetTemplates(os.DirFS("../../email-templates"))
+
+	store := &fakeStore{
+		reservations: []models.Reservation{
+			{ID: 1, Email: "arriving@here.com", StartDate: day(13), EndDate: day(15)},
+			{ID: 2, Email: "far-away@here.com", StartDate: day(20), EndDate: day(22)},
+			{ID: 3, Email: "left@here.com", StartDate: day(7), EndDate: day(9)},
+			{ID: 4, Email: "left-long-ago@here.com", StartDate: day(1), EndDate: day(2)},
+		},
+		sent: map[int]map[string]bool{},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := New(store, []Schedule{
+		{Name: mailrender.Reminder, Days: 3},
+		{Name: mailrender.CheckInInstructions, Days: 1},
+		{Name: mailrender.FollowUp, Days: 1, AfterStay: true},
+		{Name: mailrender.Change, Days: 0},
+	}, logger)
+	s.CatchUp = 2
+
+	now := time.Date(2050, 1, 10, 9, 0, 0, 0, time.UTC)
+	n, err := s.Run(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reservation 1 arrives in 3 days, reservation 3 left yesterday, the others are out of range
+	if n != 2 {
+		t.Fatalf("expected 2 emails, got %d", n)
+	}
+	if !store.sent[1][mailrender.Reminder] || !store.sent[3][mailrender.FollowUp] {
+		t.Errorf("unexpected emails sent: %v", store.sent)
+	}
+
+	// running again the same day sends nothing new
+	n, _ = s.Run(now.Add(time.Hour))
+	if n != 0 {
+		t.Errorf("expected no emails on second run, got %d", n)
+	}
+
+	// two days later reservation 1 is due for check-in instructions, its reminder is not sent again
+	n, _ = s.Run(now.AddDate(0, 0, 2))
+	if n != 1 || !store.sent[1][mailrender.CheckInInstructions] {
+		t.Errorf("expected check-in instructions only, got %d emails: %v", n, store.sent)
+	}
+
+	for _, m := range store.queued {
+		if m.Subject == "" || m.To == "" {
+			t.Errorf("queued email not rendered: %+v", m)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/csp"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// ReloadPath is where the layouts poll for template changes when reloading is on
+const ReloadPath = "/_reload"
+
+// reloadWait is how long a poll waits for a change before it is answered anyway
+const reloadWait = 20 * time.Second
+
+// cacheMu guards app.TemplateCache and the reload state below
+var cacheMu sync.RWMutex
+
+var (
+	// reloadEnabled is set by EnableReload
+	reloadEnabled bool
+	// parseErrors are the errors of the pages that failed to parse, shown in place of the page
+	parseErrors = map[string]error{}
+	// reloadVersion changes with every reload. It starts from the clock, so pages rendered before
+	// a restart refresh too
+	reloadVersion = time.Now().Unix()
+	// reloaded is closed and replaced on every reload, waking the polls waiting for it
+	reloaded = make(chan struct{})
+)
+
+// EnableReload turns template reloading on: pages come from the template cache, kept up to date by
+// Reload, a page that fails to parse is shown as an error overlay and the layouts poll ReloadPath to
+// refresh the browser when the templates change. It is meant for development with templates read
+// from disk
+func EnableReload() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	reloadEnabled = true
+}
+
+// reloading reports whether template reloading is on
+func reloading() bool {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return reloadEnabled
+}
+
+// Templates returns the template cache
+func Templates() map[string]*template.Template {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return app.TemplateCache
+}
+
+// cache returns the template cache, or the parse error of tmpl when it failed to reload
+func cache(tmpl string) (map[string]*template.Template, error) {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	if err, ok := parseErrors[tmpl]; ok {
+		return nil, err
+	}
+	return app.TemplateCache, nil
+}
+
+// Reload parses the template name again into the template cache: the page itself, or every page
+// when name is a layout. A page that fails to parse leaves the cache until it is fixed, and one that
+// was removed leaves it for good. Browsers polling ReloadPath are told to refresh
+func Reload(name string) error {
+	pages := []string{name}
+	layout := strings.HasSuffix(name, ".layout.tmpl")
+	if layout {
+		var err error
+		pages, err = fs.Glob(templates, "*.page.tmpl")
+		if err != nil {
+			return err
+		}
+	} else if !strings.HasSuffix(name, ".page.tmpl") {
+		return nil
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	// pages removed since they were parsed are gone from the glob
+	if layout {
+		seen := make(map[string]bool, len(pages))
+		for _, page := range pages {
+			seen[page] = true
+		}
+		for page := range app.TemplateCache {
+			if !seen[page] {
+				pages = append(pages, page)
+				seen[page] = true
+			}
+		}
+		for page := range parseErrors {
+			if !seen[page] {
+				pages = append(pages, page)
+			}
+		}
+	}
+
+	// the cache is copied, so the renders holding the old one are not disturbed
+	tc := make(map[string]*template.Template, len(app.TemplateCache))
+	for k, v := range app.TemplateCache {
+		tc[k] = v
+	}
+
+	var errs []error
+	for _, page := range pages {
+		if _, err := fs.Stat(templates, page); errors.Is(err, fs.ErrNotExist) {
+			delete(tc, page)
+			delete(parseErrors, page)
+			continue
+		}
+
+		t, err := parsePage(templates, page)
+		if err != nil {
+			delete(tc, page)
+			parseErrors[page] = err
+			errs = append(errs, err)
+			continue
+		}
+		tc[page] = t
+		delete(parseErrors, page)
+	}
+	app.TemplateCache = tc
+
+	reloadVersion++
+	close(reloaded)
+	reloaded = make(chan struct{})
+
+	return errors.Join(errs...)
+}
+
+// StopReload answers the polls waiting for a change, so they don't hold up shutdown
+func StopReload() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	close(reloaded)
+	reloaded = make(chan struct{})
+}
+
+// liveReloadURL returns the URL the layouts poll, carrying the version the page is rendered at, or
+// an empty string when reloading is off
+func liveReloadURL() string {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	if !reloadEnabled {
+		return ""
+	}
+	return ReloadPath + "?since=" + strconv.FormatInt(reloadVersion, 10)
+}
+
+// ReloadHandler answers the polls of the layouts: {"reload": true} as soon as the templates changed
+// since the version in the since parameter, {"reload": false} when nothing changed for a while
+func ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+
+	cacheMu.RLock()
+	version, changed := reloadVersion, reloaded
+	cacheMu.RUnlock()
+
+	if version == since {
+		select {
+		case <-changed:
+		case <-time.After(reloadWait):
+		case <-r.Context().Done():
+			return
+		}
+		cacheMu.RLock()
+		version = reloadVersion
+		cacheMu.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"reload": version != since})
+}
+
+// liveReloadScript polls the URL in LiveReload and refreshes the page when told to
+var liveReloadScript = template.Must(template.New("live-reload").Parse(`{{with .LiveReload}}<script nonce="{{$.CSPNonce}}">
+  (function () {
+    const url = {{.}};
+    function poll() {
+      fetch(url)
+        .then((res) => res.json())
+        .then((data) => (data.reload ? window.location.reload() : poll()))
+        .catch(() => setTimeout(poll, 2000));
+    }
+    poll();
+  })();
+</script>{{end}}`))
+
+// LiveReload returns the script the layouts include to refresh the page when its templates change,
+// nothing unless reloading is on
+func LiveReload(td *models.TemplateData) template.HTML {
+	var buf bytes.Buffer
+	if err := liveReloadScript.Execute(&buf, td); err != nil {
+		return ""
+	}
+	return template.HTML(buf.String())
+}
+
+// overlayTemplate shows the error of a template that failed, keeping the page polling so it
+// refreshes once the template is fixed
+var overlayTemplate = template.Must(template.New("overlay").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="UTF-8" />
+    <title>Template error</title>
+    <style>
+      body { margin: 0; background: #1e1e1e; color: #f8f8f2; font-family: monospace; }
+      .overlay { padding: 2rem; }
+      h1 { color: #ff5555; font-size: 1.25rem; }
+      pre { white-space: pre-wrap; background: #2d2d2d; padding: 1rem; border-left: 4px solid #ff5555; }
+    </style>
+  </head>
+  <body>
+    <div class="overlay">
+      <h1>Can't render {{.Template}}</h1>
+      <pre>{{.Error}}</pre>
+      <p>The page refreshes when the template is saved.</p>
+    </div>
+    {{.Script}}
+  </body>
+</html>
+`))
+
+// overlay answers r with the error err of the template tmpl, in place of the page
+func overlay(w http.ResponseWriter, r *http.Request, tmpl string, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	data := map[string]interface{}{
+		"Template": tmpl,
+		"Error":    err.Error(),
+		"Script": LiveReload(&models.TemplateData{
+			LiveReload: liveReloadURL(),
+			CSPNonce:   csp.Nonce(r.Context()),
+		}),
+	}
+	if err := overlayTemplate.Execute(w, data); err != nil {
+		fmt.Fprintln(w, err)
+	}
+}
@@ -0,0 +1,118 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+// copyTemplates copies the templates to a directory the test can edit
+func copyTemplates(t *testing.T) string {
+	dir := t.TempDir()
+	entries, err := os.ReadDir("../../templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join("../../templates", e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func poll(since string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	ReloadHandler(rr, httptest.NewRequest("GET", ReloadPath+"?since="+since, nil))
+	return rr
+}
+
+func TestReload(t *testing.T) {
+	dir := copyTemplates(t)
+	templates = os.DirFS(dir)
+	tc, err := CreateTemplateCache(templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TemplateCache = tc
+	EnableReload()
+	defer func() {
+		reloadEnabled = false
+		app.TemplateCache = nil
+	}()
+
+	render := func() *httptest.ResponseRecorder {
+		r, _ := getSession()
+		rr := httptest.NewRecorder()
+		_ = Template(rr, r, "about.page.tmpl", &models.TemplateData{})
+		return rr
+	}
+
+	rr := render()
+	since := strings.TrimPrefix(liveReloadURL(), ReloadPath+"?since=")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), liveReloadURL()) {
+		t.Fatalf("expected the page to poll for changes, got %d", rr.Code)
+	}
+
+	// a parse error is shown in place of the page
+	if err := os.WriteFile(filepath.Join(dir, "about.page.tmpl"), []byte(`{{template "base" .}}{{define "content"}}{{if}}{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload("about.page.tmpl"); err == nil {
+		t.Error("expected the parse error")
+	}
+	rr = render()
+	if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "missing value for if") || !strings.Contains(rr.Body.String(), ReloadPath) {
+		t.Errorf("expected the error overlay, got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := Templates()["home.page.tmpl"]; !ok {
+		t.Error("expected the other pages to stay in the cache")
+	}
+
+	// browsers of an older version refresh at once
+	if !strings.Contains(poll(since).Body.String(), `"reload":true`) {
+		t.Error("expected an older page to be told to refresh")
+	}
+
+	// and the others once the template is fixed
+	current := strings.TrimPrefix(liveReloadURL(), ReloadPath+"?since=")
+	answer := make(chan string)
+	go func() { answer <- poll(current).Body.String() }()
+	if err := os.WriteFile(filepath.Join(dir, "about.page.tmpl"), []byte(`{{template "base" .}}{{define "content"}}<p>About us</p>{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload("about.page.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(<-answer, `"reload":true`) {
+		t.Error("expected the waiting poll to be told to refresh")
+	}
+	if rr = render(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "About us") {
+		t.Errorf("expected the fixed page, got %d", rr.Code)
+	}
+
+	// a layout change reaches every page, a removed page leaves the cache
+	if err := os.Remove(filepath.Join(dir, "about.page.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload("base.layout.tmpl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Templates()["about.page.tmpl"]; ok {
+		t.Error("expected the removed page to leave the cache")
+	}
+
+	StopReload()
+	if LiveReload(&models.TemplateData{}) != "" {
+		t.Error("expected no script without a reload URL")
+	}
+}
@@ -2,15 +2,24 @@ package render
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
-	"path/filepath"
+	"os"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/DungBuiTien1999/bookings/internal/assets"
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/csp"
+	"github.com/DungBuiTien1999/bookings/internal/logging"
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
 	"github.com/justinas/nosurf"
 )
 
@@ -18,10 +27,17 @@ var functions = template.FuncMap{
 	"humanDate":  HumanDate,
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
+	"asset":      Asset,
+	"liveReload": LiveReload,
 }
 
 var app *config.AppConfig
-var pathToTemplates = "./templates"
+
+// templates holds the page and layout templates
+var templates fs.FS = os.DirFS("./templates")
+
+// pipeline serves the static files
+var pipeline *assets.Pipeline
 
 // Iterate returns a slice of ints, starting at 1, going to count
 func Iterate(count int) []int {
@@ -37,6 +53,25 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// SetTemplates sets the files the page and layout templates are read from
+func SetTemplates(fsys fs.FS) {
+	templates = fsys
+}
+
+// SetAssets sets the pipeline the asset template function takes fingerprinted URLs from
+func SetAssets(p *assets.Pipeline) {
+	pipeline = p
+}
+
+// Asset returns the URL of the static file name, such as css/styles.css, fingerprinted so it can
+// be cached for good
+func Asset(name string) string {
+	if pipeline == nil {
+		return "/static/" + strings.TrimPrefix(name, "/")
+	}
+	return pipeline.URL(name)
+}
+
 // HumanDate returns time in YYYY/MM/DD format
 func HumanDate(t time.Time) string {
 	return t.Format("2006 - 01 - 02")
@@ -52,72 +87,210 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.CSRFToken = nosurf.Token(r)
+	td.CSPNonce = csp.Nonce(r.Context())
+	td.LiveReload = liveReloadURL()
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
 	return td
 }
 
-// Template renders template using html/template
+// Template renders tmpl with td. When the template is missing or fails to execute, the error is
+// logged and the 500 page sent instead
 func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
-	// get the template cache from app config
+	return TemplateStatus(w, r, http.StatusOK, tmpl, td)
+}
+
+// TemplateStatus renders tmpl with td, answering with status, such as the 429 of a client over a rate
+// limit. Only 200 pages get an ETag
+func TemplateStatus(w http.ResponseWriter, r *http.Request, status int, tmpl string, td *models.TemplateData) error {
+	page, err := execute(r, tmpl, td)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't render template", "template", tmpl, "error", err)
+		if reloading() {
+			overlay(w, r, tmpl, err)
+			return err
+		}
+		Error(w, r, http.StatusInternalServerError)
+		return err
+	}
+	return write(w, r, status, page, td)
+}
+
+// errorPages are the templates of the statuses with a page of their own, the others are sent
+// error.page.tmpl
+var errorPages = map[int]string{
+	http.StatusForbidden:           "403.page.tmpl",
+	http.StatusNotFound:            "404.page.tmpl",
+	http.StatusInternalServerError: "500.page.tmpl",
+}
+
+// errorResponse is the body of errors sent to clients asking for JSON, shaped like the other JSON
+// responses of the site
+type errorResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Error answers r with status: {"ok": false, "message": "Not Found"} to clients asking for JSON,
+// otherwise the page of the status rendered through the layout. When even that page fails, the
+// status text is sent as plain text
+func Error(w http.ResponseWriter, r *http.Request, status int) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(errorResponse{OK: false, Message: http.StatusText(status)})
+		return
+	}
+
+	tmpl, ok := errorPages[status]
+	if !ok {
+		tmpl = "error.page.tmpl"
+	}
+	td := &models.TemplateData{
+		IntMap:    map[string]int{"status": status},
+		StringMap: map[string]string{"status_text": http.StatusText(status)},
+	}
+	page, err := execute(r, tmpl, td)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't render error page", "template", tmpl, "error", err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	_ = write(w, r, status, page, td)
+}
+
+// wantsJSON reports whether the client asked for JSON rather than a page
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// execute renders tmpl with td, adding the default data, into a buffer so nothing is sent when the
+// template fails
+func execute(r *http.Request, tmpl string, td *models.TemplateData) (*bytes.Buffer, error) {
+	_, span := tracing.Start(r.Context(), "render "+tmpl)
+
+	// get the template cache from app config, kept up to date by Reload when reloading is on
 	var tc map[string]*template.Template
+	var err error
 
-	if app.UseCache {
-		tc = app.TemplateCache
+	if app.UseCache || reloading() {
+		tc, err = cache(tmpl)
 	} else {
-		tc, _ = CreateTemplateCache()
+		tc, err = CreateTemplateCache(templates)
+	}
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
 	}
 
 	t, ok := tc[tmpl]
 	if !ok {
-		return errors.New("can't get template from cache")
+		err := errors.New("can't get template from cache")
+		tracing.End(span, err)
+		return nil, err
 	}
 
 	buf := new(bytes.Buffer)
 
 	td = AddDefaultData(td, r)
 
-	_ = t.Execute(buf, td)
+	err = t.Execute(buf, td)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// write sends page with status and, for 200 pages, its ETag, answering 304 when the client has it
+func write(w http.ResponseWriter, r *http.Request, status int, page *bytes.Buffer, td *models.TemplateData) error {
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if status == http.StatusOK {
+		etag := pageETag(r, page.Bytes(), td.CSRFToken, td.CSPNonce)
+		w.Header().Set("ETag", etag)
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
 
-	_, err := buf.WriteTo(w)
+	w.WriteHeader(status)
+	_, err := page.WriteTo(w)
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
+		logging.FromContext(r.Context()).Error("can't write page", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-// CreateTemplateCache creates a template cache as a map
-func CreateTemplateCache() (map[string]*template.Template, error) {
+// pageETag returns the weak ETag of a rendered page. The CSRF token is masked afresh on every
+// render, so the hash leaves it out and covers the CSRF cookie it comes from instead. The script
+// nonce changes with every response too and is left out as well: a 304 keeps the policy the
+// cached page was sent with
+func pageETag(r *http.Request, page []byte, csrfToken, nonce string) string {
+	h := sha256.New()
+	if c, err := r.Cookie(nosurf.CookieName); err == nil {
+		h.Write([]byte(c.Value))
+	}
+	for _, s := range []string{csrfToken, nonce} {
+		if s != "" {
+			page = bytes.ReplaceAll(page, []byte(s), nil)
+		}
+	}
+	h.Write(page)
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+}
+
+// matchETag reports whether the If-None-Match header ifNoneMatch lists etag, comparing weakly
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateTemplateCache creates a template cache as a map from the templates in fsys
+func CreateTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
 	myCache := map[string]*template.Template{}
 
-	pages, err := filepath.Glob(fmt.Sprintf("%s/*.page.tmpl", pathToTemplates))
+	pages, err := fs.Glob(fsys, "*.page.tmpl")
 	if err != nil {
 		return myCache, err
 	}
 
 	for _, page := range pages {
-		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := parsePage(fsys, page)
 		if err != nil {
 			return myCache, err
 		}
+		myCache[path.Base(page)] = ts
+	}
+	return myCache, nil
+}
 
-		matches, err := filepath.Glob(fmt.Sprintf("%s/*.layout.tmpl", pathToTemplates))
-		if err != nil {
-			return myCache, err
-		}
+// parsePage parses the page template page of fsys along with the layouts
+func parsePage(fsys fs.FS, page string) (*template.Template, error) {
+	ts, err := template.New(path.Base(page)).Funcs(functions).ParseFS(fsys, page)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(matches) > 0 {
-			ts, err = ts.ParseGlob(fmt.Sprintf("%s/*.layout.tmpl", pathToTemplates))
-			if err != nil {
-				return myCache, err
-			}
-		}
+	matches, err := fs.Glob(fsys, "*.layout.tmpl")
+	if err != nil {
+		return nil, err
+	}
 
-		myCache[name] = ts
+	if len(matches) > 0 {
+		ts, err = ts.ParseFS(fsys, "*.layout.tmpl")
+		if err != nil {
+			return nil, err
+		}
 	}
-	return myCache, nil
+	return ts, nil
 }
@@ -1,9 +1,14 @@
 package render
 
 import (
+	"html/template"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/DungBuiTien1999/bookings/internal/csp"
 	"github.com/DungBuiTien1999/bookings/internal/models"
 )
 
@@ -23,9 +28,9 @@ func TestAddDefaultData(t *testing.T) {
 }
 
 func TestRenderTemplate(t *testing.T) {
-	pathToTemplates = "../../templates"
+	templates = os.DirFS("../../templates")
 
-	tc, err := CreateTemplateCache()
+	tc, err := CreateTemplateCache(templates)
 	if err != nil {
 		t.Error(err)
 	}
@@ -55,13 +60,114 @@ func TestNewTemplates(t *testing.T) {
 }
 
 func TestCreateTemplateCache(t *testing.T) {
-	pathToTemplates = "../../templates"
-	_, err := CreateTemplateCache()
+	_, err := CreateTemplateCache(os.DirFS("../../templates"))
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestTemplateETag(t *testing.T) {
+	templates = os.DirFS("../../templates")
+
+	r, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(csp.WithNonce(r.Context(), csp.NewNonce()))
+	rr := httptest.NewRecorder()
+	if err := Template(rr, r, "about.page.tmpl", &models.TemplateData{}); err != nil {
+		t.Fatal(err)
+	}
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) || rr.Body.Len() == 0 {
+		t.Fatalf("expected a weak ETag and the page, got %q", etag)
+	}
+	if !strings.Contains(rr.Body.String(), `<script nonce="`+csp.Nonce(r.Context())+`">`) {
+		t.Error("expected the inline scripts to carry the nonce")
+	}
+
+	// the page is the same even though its CSRF token and script nonce change
+	r, _ = getSession()
+	r = r.WithContext(csp.WithNonce(r.Context(), csp.NewNonce()))
+	r.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	_ = Template(rr, r, "about.page.tmpl", &models.TemplateData{})
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("expected 304 without a body, got %d", rr.Code)
+	}
+
+	r, _ = getSession()
+	r.Header.Set("If-None-Match", etag)
+	session.Put(r.Context(), "flash", "Reservation saved")
+	rr = httptest.NewRecorder()
+	_ = Template(rr, r, "about.page.tmpl", &models.TemplateData{})
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a changed page to be sent, got %d", rr.Code)
+	}
+}
+
+func TestError(t *testing.T) {
+	templates = os.DirFS("../../templates")
+
+	r, _ := getSession()
+	rr := httptest.NewRecorder()
+	Error(rr, r, http.StatusNotFound)
+	if rr.Code != http.StatusNotFound || !strings.Contains(rr.Body.String(), "Page not found") || rr.Header().Get("ETag") != "" {
+		t.Errorf("expected the 404 page without an ETag, got %d", rr.Code)
+	}
+
+	r, _ = getSession()
+	rr = httptest.NewRecorder()
+	Error(rr, r, http.StatusMethodNotAllowed)
+	if rr.Code != http.StatusMethodNotAllowed || !strings.Contains(rr.Body.String(), "Method Not Allowed") {
+		t.Errorf("expected the generic error page, got %d", rr.Code)
+	}
+
+	r, _ = getSession()
+	r.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	Error(rr, r, http.StatusForbidden)
+	if rr.Code != http.StatusForbidden || strings.TrimSpace(rr.Body.String()) != `{"ok":false,"message":"Forbidden"}` {
+		t.Errorf("expected a JSON error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTemplateFailure(t *testing.T) {
+	tc, err := CreateTemplateCache(os.DirFS("../../templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc["broken.page.tmpl"] = template.Must(template.New("broken.page.tmpl").Parse("{{.Missing}}"))
+	app.UseCache, app.TemplateCache = true, tc
+	defer func() { app.UseCache, app.TemplateCache = false, nil }()
+
+	for _, tmpl := range []string{"broken.page.tmpl", "missing.page.tmpl"} {
+		r, _ := getSession()
+		rr := httptest.NewRecorder()
+		if err := Template(rr, r, tmpl, &models.TemplateData{}); err == nil {
+			t.Errorf("expected an error rendering %s", tmpl)
+		}
+		if rr.Code != http.StatusInternalServerError || !strings.Contains(rr.Body.String(), "Something went wrong") {
+			t.Errorf("expected the 500 page for %s, got %d", tmpl, rr.Code)
+		}
+	}
+
+	r, _ := getSession()
+	rr := httptest.NewRecorder()
+	if err := TemplateStatus(rr, r, http.StatusTooManyRequests, "too-many-requests.page.tmpl", &models.TemplateData{}); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the status of the page, got %d", rr.Code)
+	}
+}
+
+func TestAsset(t *testing.T) {
+	if Asset("css/styles.css") != "/static/css/styles.css" {
+		t.Errorf("expected the plain url without a pipeline, got %q", Asset("css/styles.css"))
+	}
+}
+
 func getSession() (*http.Request, error) {
 	req, err := http.NewRequest("GET", "/some-url", nil)
 	if err != nil {
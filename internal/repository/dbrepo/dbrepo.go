@@ -1,15 +1,22 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/config"
+	"github.com/DungBuiTien1999/bookings/internal/metrics"
 	"github.com/DungBuiTien1999/bookings/internal/repository"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
 )
 
 type mysqlDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// ctx is the context of the request the repository is bound to, nil when it is not
+	ctx context.Context
 }
 
 type testDBRepo struct {
@@ -29,3 +36,32 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// WithContext returns a copy of the repository whose spans join the trace of ctx
+func (m *mysqlDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	r := *m
+	r.ctx = ctx
+	return &r
+}
+
+// observe starts the span of the repository method name and returns its context, along with the
+// function ending the span and recording how long the method took. The context is not cancelled
+// with the request, so a client going away does not abort a write half way
+func (m *mysqlDBRepo) observe(name string) (context.Context, func()) {
+	start := time.Now()
+	parent := context.Background()
+	if m.ctx != nil {
+		parent = context.WithoutCancel(m.ctx)
+	}
+
+	ctx, span := tracing.Start(parent, "db."+name)
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(name, start)
+	}
+}
+
+// WithContext returns the repository itself, the test repository has nothing to trace
+func (m *testDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	return m
+}
@@ -2,11 +2,15 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
-	"log"
+	"strings"
 	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,8 +20,11 @@ func (m *mysqlDBRepo) AllUsers() bool {
 
 // InsertReservation inserts a reservation into database
 func (m *mysqlDBRepo) InsertReservation(res models.Reservation) (int, error) {
+	ctx, done := m.observe("InsertReservation")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	stmt := `insert into reservations 
@@ -50,10 +57,92 @@ func (m *mysqlDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return newId, nil
 }
 
+// InsertReservationWithMail inserts a reservation, the room restriction holding its dates and
+// the notifications about it, built by mail from the new id, in a single transaction, and returns
+// the new reservation id
+func (m *mysqlDBRepo) InsertReservationWithMail(res models.Reservation, r models.RoomRestriction, mail func(id int) ([]models.MailData, error)) (int, error) {
+	ctx, done := m.observe("InsertReservationWithMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into reservations 
+	(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at) 
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions 
+	(start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at) 
+	values (?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		newID,
+		r.RestrictionID,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	msgs, err := mail(int(newID))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, x := range msgs {
+		err = insertMail(ctx, tx, x)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
 // InsertRoomRestriction inserts a room restriction into database
 func (m *mysqlDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+	ctx, done := m.observe("InsertRoomRestriction")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	stmt := `insert into room_restrictions 
@@ -79,8 +168,11 @@ func (m *mysqlDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 
 // SearchAvailabilityByDatesByRoomID returns true if availability exist for roomID otherwise false
 func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	ctx, done := m.observe("SearchAvailabilityByDatesByRoomID")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `select count(id) from room_restrictions where room_id = ? and ? < end_date and ? > start_date`
@@ -97,8 +189,11 @@ func (m *mysqlDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, ro
 
 // SearchAvailabilityForAllRooms returns a slice of availability room, if any, for given date range
 func (m *mysqlDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+	ctx, done := m.observe("SearchAvailabilityForAllRooms")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
@@ -137,8 +232,11 @@ func (m *mysqlDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mod
 
 // GetRoomByID return room by id
 func (m *mysqlDBRepo) GetRoomByID(id int) (models.Room, error) {
+	ctx, done := m.observe("GetRoomByID")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -160,8 +258,11 @@ func (m *mysqlDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 // GetUserByID returns a user by id
 func (m *mysqlDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, done := m.observe("GetUserByID")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -186,8 +287,11 @@ func (m *mysqlDBRepo) GetUserByID(id int) (models.User, error) {
 
 // UpdateUser updates a user in database
 func (m *mysqlDBRepo) UpdateUser(u models.User) error {
+	ctx, done := m.observe("UpdateUser")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -209,8 +313,11 @@ func (m *mysqlDBRepo) UpdateUser(u models.User) error {
 
 // Authenticate authenticates a user
 func (m *mysqlDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	ctx, done := m.observe("Authenticate")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var id int
@@ -221,7 +328,7 @@ func (m *mysqlDBRepo) Authenticate(email, testPassword string) (int, string, err
 		&hashedPassword,
 	)
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.Info("login for unknown user", "error", err)
 		return id, "", err
 	}
 
@@ -237,7 +344,10 @@ func (m *mysqlDBRepo) Authenticate(email, testPassword string) (int, string, err
 
 // AllReservations returns a slice of all reservations
 func (m *mysqlDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("AllReservations")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var reservations []models.Reservation
@@ -247,6 +357,7 @@ func (m *mysqlDBRepo) AllReservations() ([]models.Reservation, error) {
 	r.processed, rm.id, rm.room_name
 	from reservations as r
 	left join rooms as rm on (r.room_id = rm.id)
+	where r.deleted_at is null
 	order by r.start_date asc
 	`
 
@@ -288,7 +399,10 @@ func (m *mysqlDBRepo) AllReservations() ([]models.Reservation, error) {
 
 // AllNewReservations returns a slice of all new reservations
 func (m *mysqlDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("AllNewReservations")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var reservations []models.Reservation
@@ -298,7 +412,7 @@ func (m *mysqlDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	rm.id, rm.room_name
 	from reservations as r
 	left join rooms as rm on (r.room_id = rm.id)
-	where r.processed = 0
+	where r.processed = 0 and r.deleted_at is null
 	order by r.start_date asc
 	`
 
@@ -339,14 +453,18 @@ func (m *mysqlDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 // GetReservationByID takes reservation by id
 func (m *mysqlDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("GetReservationByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var reservation models.Reservation
+	var deletedAt sql.NullTime
 	query := `
 	select r.id, r.first_name, r.last_name, r.email, r.phone, 
 	r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-	r.processed, rm.id, rm.room_name
+	r.processed, r.deleted_at, rm.id, rm.room_name
 	from reservations as r
 	left join rooms as rm on (r.room_id = rm.id)
 	where r.id = ?
@@ -363,19 +481,24 @@ func (m *mysqlDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 		&reservation.CreatedAt,
 		&reservation.UpdatedAt,
 		&reservation.Processed,
+		&deletedAt,
 		&reservation.Room.ID,
 		&reservation.Room.RoomName,
 	)
 	if err != nil {
 		return reservation, err
 	}
+	reservation.DeletedAt = deletedAt.Time
 	return reservation, nil
 }
 
 // UpdateReservation updates a reservation in database
 func (m *mysqlDBRepo) UpdateReservation(r models.Reservation) error {
+	ctx, done := m.observe("UpdateReservation")
+	defer done()
+
 	// request last longer 3 second so discard write record into db
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -396,23 +519,239 @@ func (m *mysqlDBRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
-// DeleteReservation deletes a reservation by id from database
+// DeleteReservation moves a reservation to the trash and releases the dates it held. A reservation
+// already in the trash, or missing, is sql.ErrNoRows
 func (m *mysqlDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("DeleteReservation")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	query := `delete from reservations where id = ?`
-	_, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set deleted_at = ?, updated_at = ? where id = ? and deleted_at is null`
+	result, err := tx.ExecContext(ctx, query, time.Now(), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	query = `delete from room_restrictions where reservation_id = ?`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AllDeletedReservations returns a slice of all reservations in the trash
+func (m *mysqlDBRepo) AllDeletedReservations() ([]models.Reservation, error) {
+	ctx, done := m.observe("AllDeletedReservations")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, 
+	r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.processed, r.deleted_at, rm.id, rm.room_name
+	from reservations as r
+	left join rooms as rm on (r.room_id = rm.id)
+	where r.deleted_at is not null
+	order by r.deleted_at desc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.DeletedAt,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// RestoreReservation takes a reservation out of the trash, holding its dates again. It returns
+// repository.ErrRoomNotAvailable if the room has been booked or blocked in the meantime
+func (m *mysqlDBRepo) RestoreReservation(id int) error {
+	ctx, done := m.observe("RestoreReservation")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var startDate, endDate time.Time
+	query := `select room_id, start_date, end_date from reservations where id = ? and deleted_at is not null for update`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&roomID, &startDate, &endDate)
+	if err != nil {
+		return err
+	}
+
+	// lock the room so nobody books it between the check and the insert
+	query = `select id from rooms where id = ? for update`
+	err = tx.QueryRowContext(ctx, query, roomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query = `select count(id) from room_restrictions where room_id = ? and ? < end_date and ? > start_date`
+	err = tx.QueryRowContext(ctx, query, roomID, startDate, endDate).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	query = `insert into room_restrictions 
+	(start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at) 
+	values (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query, startDate, endDate, roomID, id, 1, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	query = `update reservations set deleted_at = null, updated_at = ? where id = ?`
+	_, err = tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeReservation permanently deletes a reservation in the trash
+func (m *mysqlDBRepo) PurgeReservation(id int) error {
+	ctx, done := m.observe("PurgeReservation")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `delete from reservations where id = ? and deleted_at is not null`
+	result, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
+// PurgeDeletedReservations permanently deletes reservations moved to the trash before the given time
+// and returns how many were removed
+func (m *mysqlDBRepo) PurgeDeletedReservations(before time.Time) (int, error) {
+	ctx, done := m.observe("PurgeDeletedReservations")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `delete from reservations where deleted_at is not null and deleted_at < ?`
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// NextInviteSequence increments the calendar invite sequence of a reservation and returns it
+func (m *mysqlDBRepo) NextInviteSequence(id int) (int, error) {
+	ctx, done := m.observe("NextInviteSequence")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// last_insert_id hands the new value back to this connection, so two updates never share it
+	query := `update reservations set invite_sequence = last_insert_id(invite_sequence + 1) where id = ?`
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	seq, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(seq), nil
+}
+
 // UpdateProcessedForReservation updates processed of reservation by id
 func (m *mysqlDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("UpdateProcessedForReservation")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `update reservations set processed = ? where id = ?`
@@ -426,7 +765,10 @@ func (m *mysqlDBRepo) UpdateProcessedForReservation(id, processed int) error {
 
 // AllRooms gets all rooms in database
 func (m *mysqlDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("AllRooms")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
@@ -461,7 +803,10 @@ func (m *mysqlDBRepo) AllRooms() ([]models.Room, error) {
 
 // GetRestrictionsForRoomByDate returns restrictions for room by date range
 func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("GetRestrictionsForRoomByDate")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
@@ -498,9 +843,12 @@ func (m *mysqlDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.T
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertBlockForRoom inserts a room restriction and returns its id
+func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
+	ctx, done := m.observe("InsertBlockForRoom")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -508,16 +856,24 @@ func (m *mysqlDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 	values (?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
+	result, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(newID), nil
 }
 
 // DeleteBlockByID deletes a room restriction
 func (m *mysqlDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, done := m.observe("DeleteBlockByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	query := `
@@ -530,3 +886,1580 @@ func (m *mysqlDBRepo) DeleteBlockByID(id int) error {
 	}
 	return nil
 }
+
+// InsertAuditLog inserts an audit log entry into database
+func (m *mysqlDBRepo) InsertAuditLog(a models.AuditLog) error {
+	ctx, done := m.observe("InsertAuditLog")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into audit_logs
+	(user_id, action, entity_type, entity_id, before_data, after_data, diff, ip_address, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		a.UserID,
+		a.Action,
+		a.EntityType,
+		a.EntityID,
+		a.Before,
+		a.After,
+		a.Diff,
+		a.IPAddress,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllAuditLogs returns audit log entries matching the filter, newest first
+func (m *mysqlDBRepo) AllAuditLogs(f models.AuditFilter) ([]models.AuditLog, error) {
+	ctx, done := m.observe("AllAuditLogs")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var logs []models.AuditLog
+
+	query := `
+	select a.id, a.user_id, a.action, a.entity_type, a.entity_id,
+	COALESCE(a.before_data, ''), COALESCE(a.after_data, ''), COALESCE(a.diff, ''),
+	a.ip_address, a.created_at, COALESCE(u.email, '')
+	from audit_logs as a
+	left join users as u on (a.user_id = u.id)
+	where 1 = 1
+	`
+	var args []interface{}
+
+	if f.UserID > 0 {
+		query += " and a.user_id = ?"
+		args = append(args, f.UserID)
+	}
+	if f.Action != "" {
+		query += " and a.action = ?"
+		args = append(args, f.Action)
+	}
+	if f.EntityType != "" {
+		query += " and a.entity_type = ?"
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID > 0 {
+		query += " and a.entity_id = ?"
+		args = append(args, f.EntityID)
+	}
+	if !f.From.IsZero() {
+		query += " and a.created_at >= ?"
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		query += " and a.created_at < ?"
+		args = append(args, f.To)
+	}
+	query += " order by a.created_at desc, a.id desc"
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return logs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.AuditLog
+		err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Before,
+			&i.After,
+			&i.Diff,
+			&i.IPAddress,
+			&i.CreatedAt,
+			&i.User.Email,
+		)
+		if err != nil {
+			return logs, err
+		}
+		i.User.ID = i.UserID
+		logs = append(logs, i)
+	}
+	if err = rows.Err(); err != nil {
+		return logs, err
+	}
+
+	return logs, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// traceContext returns the traceparent kept with queued work: the one it was given, or else the
+// trace of ctx
+func traceContext(ctx context.Context, traceparent string) sql.NullString {
+	if traceparent == "" {
+		traceparent = tracing.Inject(ctx)
+	}
+	return sql.NullString{String: traceparent, Valid: traceparent != ""}
+}
+
+// insertMail adds a message to the mail outbox, due for delivery straight away. Attachments are
+// kept with the message as JSON
+func insertMail(ctx context.Context, db execer, mail models.MailData) error {
+	var attachments sql.NullString
+	if len(mail.Attachments) > 0 {
+		b, err := json.Marshal(mail.Attachments)
+		if err != nil {
+			return err
+		}
+		attachments = sql.NullString{String: string(b), Valid: true}
+	}
+
+	stmt := `insert into mail_outbox
+	(to_address, from_address, subject, content, plain_content, attachments, status, attempts, next_attempt_at,
+	trace_context, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := db.ExecContext(ctx, stmt,
+		mail.To,
+		mail.From,
+		mail.Subject,
+		mail.Content,
+		mail.PlainContent,
+		attachments,
+		models.MailStatusPending,
+		0,
+		time.Now(),
+		traceContext(ctx, mail.TraceContext),
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// decodeAttachments reads the attachments of a message in the mail outbox back from JSON
+func decodeAttachments(attachments sql.NullString) ([]models.Attachment, error) {
+	var a []models.Attachment
+	if !attachments.Valid || attachments.String == "" {
+		return a, nil
+	}
+	err := json.Unmarshal([]byte(attachments.String), &a)
+	return a, err
+}
+
+// EnqueueMail adds a message to the mail outbox
+func (m *mysqlDBRepo) EnqueueMail(mail models.MailData) error {
+	ctx, done := m.observe("EnqueueMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return insertMail(ctx, m.DB, mail)
+}
+
+// ClaimOutboxMail leases up to limit messages that are due for delivery, including messages whose
+// previous lease ran out, so that no other worker picks them up until the lease expires
+func (m *mysqlDBRepo) ClaimOutboxMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	ctx, done := m.observe("ClaimOutboxMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var mail []models.OutboxMail
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return mail, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `
+	select id, to_address, from_address, subject, content, COALESCE(plain_content, ''), attachments, status,
+	attempts, next_attempt_at, COALESCE(last_error, ''), COALESCE(trace_context, ''), created_at, updated_at
+	from mail_outbox
+	where (status = ? and next_attempt_at <= ?) or (status = ? and locked_until < ?)
+	order by next_attempt_at asc
+	limit ?
+	for update skip locked
+	`
+
+	rows, err := tx.QueryContext(ctx, query, models.MailStatusPending, now, models.MailStatusSending, now, limit)
+	if err != nil {
+		return mail, err
+	}
+
+	for rows.Next() {
+		var i models.OutboxMail
+		var attachments sql.NullString
+		err := rows.Scan(
+			&i.ID,
+			&i.Mail.To,
+			&i.Mail.From,
+			&i.Mail.Subject,
+			&i.Mail.Content,
+			&i.Mail.PlainContent,
+			&attachments,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.Mail.TraceContext,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return mail, err
+		}
+		i.Mail.Attachments, err = decodeAttachments(attachments)
+		if err != nil {
+			rows.Close()
+			return mail, err
+		}
+		mail = append(mail, i)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return mail, err
+	}
+	rows.Close()
+
+	stmt := `update mail_outbox set status = ?, locked_until = ?, updated_at = ? where id = ?`
+	for i := range mail {
+		_, err = tx.ExecContext(ctx, stmt, models.MailStatusSending, now.Add(lease), now, mail[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		mail[i].Status = models.MailStatusSending
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return mail, nil
+}
+
+// MarkOutboxMailSent records that a message was delivered
+func (m *mysqlDBRepo) MarkOutboxMailSent(id int) error {
+	ctx, done := m.observe("MarkOutboxMailSent")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = ?, attempts = attempts + 1, sent_at = ?, locked_until = null,
+	last_error = null, updated_at = ? where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, models.MailStatusSent, time.Now(), time.Now(), id)
+	return err
+}
+
+// MarkOutboxMailFailed records a failed delivery attempt. The message is retried at nextAttempt,
+// or moved to the dead letter state when dead is true
+func (m *mysqlDBRepo) MarkOutboxMailFailed(id int, lastError string, nextAttempt time.Time, dead bool) error {
+	ctx, done := m.observe("MarkOutboxMailFailed")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	status := models.MailStatusPending
+	if dead {
+		status = models.MailStatusDead
+	}
+
+	stmt := `update mail_outbox set status = ?, attempts = attempts + 1, next_attempt_at = ?, locked_until = null,
+	last_error = ?, updated_at = ? where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, status, nextAttempt, lastError, time.Now(), id)
+	return err
+}
+
+// AllOutboxMail returns messages in the mail outbox with the given status, or all of them when
+// status is empty, newest first
+func (m *mysqlDBRepo) AllOutboxMail(status string) ([]models.OutboxMail, error) {
+	ctx, done := m.observe("AllOutboxMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var mail []models.OutboxMail
+
+	query := `
+	select id, to_address, from_address, subject, content, COALESCE(plain_content, ''), status, attempts,
+	next_attempt_at, COALESCE(last_error, ''), sent_at, created_at, updated_at
+	from mail_outbox
+	`
+	var args []interface{}
+	if status != "" {
+		query += " where status = ?"
+		args = append(args, status)
+	}
+	query += " order by created_at desc, id desc limit 500"
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return mail, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.OutboxMail
+		var sentAt sql.NullTime
+		err := rows.Scan(
+			&i.ID,
+			&i.Mail.To,
+			&i.Mail.From,
+			&i.Mail.Subject,
+			&i.Mail.Content,
+			&i.Mail.PlainContent,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&sentAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			return mail, err
+		}
+		i.SentAt = sentAt.Time
+		mail = append(mail, i)
+	}
+	if err = rows.Err(); err != nil {
+		return mail, err
+	}
+
+	return mail, nil
+}
+
+// GetOutboxMailByID returns a message from the mail outbox by id
+func (m *mysqlDBRepo) GetOutboxMailByID(id int) (models.OutboxMail, error) {
+	ctx, done := m.observe("GetOutboxMailByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var i models.OutboxMail
+	var sentAt sql.NullTime
+	var attachments sql.NullString
+	query := `
+	select id, to_address, from_address, subject, content, COALESCE(plain_content, ''), attachments, status,
+	attempts, next_attempt_at, COALESCE(last_error, ''), sent_at, created_at, updated_at
+	from mail_outbox where id = ?
+	`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&i.ID,
+		&i.Mail.To,
+		&i.Mail.From,
+		&i.Mail.Subject,
+		&i.Mail.Content,
+		&i.Mail.PlainContent,
+		&attachments,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastError,
+		&sentAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return i, err
+	}
+	i.SentAt = sentAt.Time
+	i.Mail.Attachments, err = decodeAttachments(attachments)
+	return i, err
+}
+
+// ResendOutboxMail queues a message for delivery again with a fresh retry budget
+func (m *mysqlDBRepo) ResendOutboxMail(id int) error {
+	ctx, done := m.observe("ResendOutboxMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update mail_outbox set status = ?, attempts = 0, next_attempt_at = ?, locked_until = null,
+	updated_at = ? where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, models.MailStatusPending, time.Now(), time.Now(), id)
+	return err
+}
+
+// AllEmailTemplates returns the email templates edited by admins
+func (m *mysqlDBRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	ctx, done := m.observe("AllEmailTemplates")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var templates []models.EmailTemplate
+
+	query := `select id, name, subject, html_body, text_body, created_at, updated_at
+	from email_templates order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.EmailTemplate
+		err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.Subject,
+			&t.HTMLBody,
+			&t.TextBody,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+	if err = rows.Err(); err != nil {
+		return templates, err
+	}
+
+	return templates, nil
+}
+
+// GetEmailTemplate returns the admin edited email template with the given name, or
+// sql.ErrNoRows when the built-in template is in use
+func (m *mysqlDBRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	ctx, done := m.observe("GetEmailTemplate")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var t models.EmailTemplate
+	query := `select id, name, subject, html_body, text_body, created_at, updated_at
+	from email_templates where name = ?`
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&t.ID,
+		&t.Name,
+		&t.Subject,
+		&t.HTMLBody,
+		&t.TextBody,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	return t, err
+}
+
+// UpsertEmailTemplate inserts or replaces the edited version of an email template and returns its id
+func (m *mysqlDBRepo) UpsertEmailTemplate(t models.EmailTemplate) (int, error) {
+	ctx, done := m.observe("UpsertEmailTemplate")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// last_insert_id(id) makes the id of an updated row available like the one of an inserted row
+	stmt := `insert into email_templates (name, subject, html_body, text_body, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?)
+	on duplicate key update id = last_insert_id(id), subject = values(subject), html_body = values(html_body),
+	text_body = values(text_body), updated_at = values(updated_at)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		t.Name,
+		t.Subject,
+		t.HTMLBody,
+		t.TextBody,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// DeleteEmailTemplate removes the edited version of an email template, so the built-in one is used again
+func (m *mysqlDBRepo) DeleteEmailTemplate(name string) error {
+	ctx, done := m.observe("DeleteEmailTemplate")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from email_templates where name = ?", name)
+	return err
+}
+
+// ReservationsArrivingBetween returns the reservations, not in the trash, that start between start and end inclusive
+func (m *mysqlDBRepo) ReservationsArrivingBetween(start, end time.Time) ([]models.Reservation, error) {
+	ctx, done := m.observe("ReservationsArrivingBetween")
+	defer done()
+
+	return m.reservationsBetween(ctx, "start_date", start, end)
+}
+
+// ReservationsDepartingBetween returns the reservations, not in the trash, that end between start and end inclusive
+func (m *mysqlDBRepo) ReservationsDepartingBetween(start, end time.Time) ([]models.Reservation, error) {
+	ctx, done := m.observe("ReservationsDepartingBetween")
+	defer done()
+
+	return m.reservationsBetween(ctx, "end_date", start, end)
+}
+
+// reservationsBetween returns the reservations not in the trash whose date column is between start and end inclusive
+func (m *mysqlDBRepo) reservationsBetween(ctx context.Context, column string, start, end time.Time) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+	query := `
+	select r.id, r.first_name, r.last_name, r.email, r.phone,
+	r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+	r.processed, rm.id, rm.room_name
+	from reservations as r
+	left join rooms as rm on (r.room_id = rm.id)
+	where r.deleted_at is null and r.` + column + ` between ? and ?
+	order by r.` + column + ` asc
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// InsertNotificationWithMail records that the kind message was sent for a reservation and queues it
+// in the mail outbox, in a single transaction. It returns false, queueing nothing, when the message
+// has been sent for the reservation before
+func (m *mysqlDBRepo) InsertNotificationWithMail(reservationID int, kind string, mail models.MailData) (bool, error) {
+	ctx, done := m.observe("InsertNotificationWithMail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// the unique key on reservation and kind makes the insert a no-op for a message already sent
+	stmt := `insert ignore into reservation_notifications (reservation_id, kind, created_at, updated_at)
+	values (?, ?, ?, ?)`
+
+	result, err := tx.ExecContext(ctx, stmt, reservationID, kind, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	err = insertMail(ctx, tx, mail)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// NotificationsForReservation returns the scheduled messages sent for a reservation
+func (m *mysqlDBRepo) NotificationsForReservation(id int) ([]models.Notification, error) {
+	ctx, done := m.observe("NotificationsForReservation")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var notifications []models.Notification
+
+	query := `select id, reservation_id, kind, created_at from reservation_notifications
+	where reservation_id = ? order by created_at asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.ReservationID,
+			&n.Kind,
+			&n.CreatedAt,
+		)
+		if err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, n)
+	}
+	if err = rows.Err(); err != nil {
+		return notifications, err
+	}
+
+	return notifications, nil
+}
+
+// jobColumns are the columns scanned by scanJob
+const jobColumns = `id, name, COALESCE(job_key, ''), schedule, COALESCE(payload, ''), status, attempts, run_at,
+	COALESCE(locked_by, ''), locked_until, last_run_at, COALESCE(last_error, ''), COALESCE(trace_context, ''),
+	created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob reads a row of jobColumns into a job
+func scanJob(row scanner) (models.Job, error) {
+	var j models.Job
+	var lockedUntil, lastRunAt sql.NullTime
+	err := row.Scan(
+		&j.ID,
+		&j.Name,
+		&j.Key,
+		&j.Schedule,
+		&j.Payload,
+		&j.Status,
+		&j.Attempts,
+		&j.RunAt,
+		&j.LockedBy,
+		&lockedUntil,
+		&lastRunAt,
+		&j.LastError,
+		&j.TraceContext,
+		&j.CreatedAt,
+		&j.UpdatedAt,
+	)
+	j.LockedUntil = lockedUntil.Time
+	j.LastRunAt = lastRunAt.Time
+	return j, err
+}
+
+// RegisterRecurringJob adds the name recurring job, due at runAt, unless it exists. When it exists
+// with a different schedule the schedule is changed and the job is due at runAt instead
+func (m *mysqlDBRepo) RegisterRecurringJob(name, schedule string, runAt time.Time) error {
+	ctx, done := m.observe("RegisterRecurringJob")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// run_at is assigned first so it still compares against the old schedule
+	stmt := `insert into jobs (name, job_key, schedule, status, attempts, run_at, created_at, updated_at)
+	values (?, ?, ?, ?, 0, ?, ?, ?)
+	on duplicate key update
+	run_at = if(schedule = values(schedule), run_at, values(run_at)),
+	schedule = values(schedule), updated_at = values(updated_at)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		name,
+		name,
+		schedule,
+		models.JobStatusScheduled,
+		runAt,
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// EnqueueJob adds a one-off job and returns its id. A job with the key of an existing one is not
+// added and 0 is returned
+func (m *mysqlDBRepo) EnqueueJob(j models.Job) (int, error) {
+	ctx, done := m.observe("EnqueueJob")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var key sql.NullString
+	if j.Key != "" {
+		key = sql.NullString{String: j.Key, Valid: true}
+	}
+
+	stmt := `insert ignore into jobs (name, job_key, payload, status, attempts, run_at, trace_context, created_at,
+	updated_at)
+	values (?, ?, ?, ?, 0, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		j.Name,
+		key,
+		j.Payload,
+		models.JobStatusScheduled,
+		j.RunAt,
+		traceContext(ctx, j.TraceContext),
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// ClaimJobs leases up to limit due jobs to owner. Jobs still running when their lease ran out,
+// because the instance running them died, are claimed again
+func (m *mysqlDBRepo) ClaimJobs(limit int, lease time.Duration, owner string) ([]models.Job, error) {
+	ctx, done := m.observe("ClaimJobs")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var jobs []models.Job
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return jobs, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `select ` + jobColumns + ` from jobs
+	where (status = ? and run_at <= ?) or (status = ? and locked_until < ?)
+	order by run_at asc
+	limit ?
+	for update skip locked`
+
+	rows, err := tx.QueryContext(ctx, query, models.JobStatusScheduled, now, models.JobStatusRunning, now, limit)
+	if err != nil {
+		return jobs, err
+	}
+
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return jobs, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return jobs, err
+	}
+	rows.Close()
+
+	stmt := `update jobs set status = ?, locked_by = ?, locked_until = ?, updated_at = ? where id = ?`
+	for i := range jobs {
+		_, err = tx.ExecContext(ctx, stmt, models.JobStatusRunning, owner, now.Add(lease), now, jobs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i].Status = models.JobStatusRunning
+		jobs[i].LockedBy = owner
+		jobs[i].LockedUntil = now.Add(lease)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// FinishJob records the outcome of a run of j and releases its lease, as long as owner still holds
+// the lease. A lease that ran out and went to another runner is sql.ErrNoRows
+func (m *mysqlDBRepo) FinishJob(j models.Job, owner string) error {
+	ctx, done := m.observe("FinishJob")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var lastError sql.NullString
+	if j.LastError != "" {
+		lastError = sql.NullString{String: j.LastError, Valid: true}
+	}
+
+	stmt := `update jobs set status = ?, attempts = ?, run_at = ?, last_run_at = ?, last_error = ?,
+	locked_by = null, locked_until = null, updated_at = ? where id = ? and locked_by = ? and status = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		j.Status,
+		j.Attempts,
+		j.RunAt,
+		j.LastRunAt,
+		lastError,
+		time.Now(),
+		j.ID,
+		owner,
+		models.JobStatusRunning,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AllJobs returns the recurring jobs followed by the one-off jobs, newest first
+func (m *mysqlDBRepo) AllJobs() ([]models.Job, error) {
+	ctx, done := m.observe("AllJobs")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var jobs []models.Job
+
+	query := `select ` + jobColumns + ` from jobs
+	order by schedule = '' asc, name asc, run_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, j)
+	}
+	if err = rows.Err(); err != nil {
+		return jobs, err
+	}
+
+	return jobs, nil
+}
+
+// GetJobByID returns a job by id
+func (m *mysqlDBRepo) GetJobByID(id int) (models.Job, error) {
+	ctx, done := m.observe("GetJobByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `select ` + jobColumns + ` from jobs where id = ?`
+	return scanJob(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// RunJobNow makes a job that is not running due straight away, with a fresh retry budget
+func (m *mysqlDBRepo) RunJobNow(id int) error {
+	ctx, done := m.observe("RunJobNow")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update jobs set status = ?, attempts = 0, run_at = ?, updated_at = ? where id = ? and status <> ?`
+	_, err := m.DB.ExecContext(ctx, stmt, models.JobStatusScheduled, time.Now(), time.Now(), id, models.JobStatusRunning)
+	return err
+}
+
+// AllStaff returns the users, who are all staff, with their notification settings
+func (m *mysqlDBRepo) AllStaff() ([]models.User, error) {
+	ctx, done := m.observe("AllStaff")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select u.id, u.first_name, u.last_name, u.email, u.access_level, coalesce(w.id, 0), coalesce(w.url, ''),
+	u.created_at, u.updated_at
+	from users u
+	left join webhooks w on (w.user_id = u.id)
+	order by u.last_name asc, u.first_name asc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.WebhookID,
+			&u.WebhookURL,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// notificationSubscriptions returns the subscriptions matching where, with their user
+func (m *mysqlDBRepo) notificationSubscriptions(ctx context.Context, where string, args ...interface{}) ([]models.NotificationSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var subscriptions []models.NotificationSubscription
+
+	query := `select s.id, s.user_id, s.event_type, s.channel, s.created_at,
+	u.id, u.first_name, u.last_name, u.email, coalesce(w.id, 0), coalesce(w.url, '')
+	from notification_subscriptions s
+	left join users u on (s.user_id = u.id)
+	left join webhooks w on (w.user_id = u.id and w.active = 1)
+	` + where + `
+	order by s.user_id asc, s.event_type asc, s.channel asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return subscriptions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.NotificationSubscription
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.EventType,
+			&s.Channel,
+			&s.CreatedAt,
+			&s.User.ID,
+			&s.User.FirstName,
+			&s.User.LastName,
+			&s.User.Email,
+			&s.User.WebhookID,
+			&s.User.WebhookURL,
+		)
+		if err != nil {
+			return subscriptions, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return subscriptions, err
+	}
+
+	return subscriptions, nil
+}
+
+// AllNotificationSubscriptions returns the notification subscriptions of all staff
+func (m *mysqlDBRepo) AllNotificationSubscriptions() ([]models.NotificationSubscription, error) {
+	ctx, done := m.observe("AllNotificationSubscriptions")
+	defer done()
+
+	return m.notificationSubscriptions(ctx, "")
+}
+
+// SubscriptionsForEvent returns the subscriptions to an event type, with the user to notify
+func (m *mysqlDBRepo) SubscriptionsForEvent(eventType string) ([]models.NotificationSubscription, error) {
+	ctx, done := m.observe("SubscriptionsForEvent")
+	defer done()
+
+	return m.notificationSubscriptions(ctx, "where s.event_type = ?", eventType)
+}
+
+// UpdateNotificationSettings replaces the webhook address and the subscriptions of a user. The
+// address is kept as the user's own webhook, given a secret when it is added, and an empty one
+// removes it with its delivery log
+func (m *mysqlDBRepo) UpdateNotificationSettings(userID int, webhookURL string, subscriptions []models.NotificationSubscription) error {
+	ctx, done := m.observe("UpdateNotificationSettings")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if webhookURL == "" {
+		_, err = tx.ExecContext(ctx, `delete from webhooks where user_id = ?`, userID)
+	} else {
+		_, err = tx.ExecContext(ctx, `insert into webhooks (url, description, secret, events, active, user_id, created_at, updated_at)
+		select ?, concat('Notifications of ', email), hex(random_bytes(32)), '', 1, id, ?, ? from users where id = ?
+		on duplicate key update url = ?, updated_at = ?`,
+			webhookURL, time.Now(), time.Now(), userID, webhookURL, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from notification_subscriptions where user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into notification_subscriptions (user_id, event_type, channel, created_at, updated_at)
+	values (?, ?, ?, ?, ?)`
+	for _, s := range subscriptions {
+		_, err = tx.ExecContext(ctx, stmt, userID, s.EventType, s.Channel, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertFeedItem adds an entry to a user's notification feed
+func (m *mysqlDBRepo) InsertFeedItem(f models.FeedItem) error {
+	ctx, done := m.observe("InsertFeedItem")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into notification_feed (user_id, event_type, title, body, link, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		f.UserID,
+		f.EventType,
+		f.Title,
+		f.Body,
+		f.Link,
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
+
+// FeedForUser returns the latest limit entries of a user's notification feed, newest first
+func (m *mysqlDBRepo) FeedForUser(userID, limit int) ([]models.FeedItem, error) {
+	ctx, done := m.observe("FeedForUser")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var items []models.FeedItem
+
+	query := `select id, user_id, event_type, title, COALESCE(body, ''), link, read_at, created_at
+	from notification_feed where user_id = ? order by created_at desc, id desc limit ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.FeedItem
+		var readAt sql.NullTime
+		err := rows.Scan(
+			&f.ID,
+			&f.UserID,
+			&f.EventType,
+			&f.Title,
+			&f.Body,
+			&f.Link,
+			&readAt,
+			&f.CreatedAt,
+		)
+		if err != nil {
+			return items, err
+		}
+		f.ReadAt = readAt.Time
+		items = append(items, f)
+	}
+	if err = rows.Err(); err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
+
+// MarkFeedRead marks every entry of a user's notification feed as read
+func (m *mysqlDBRepo) MarkFeedRead(userID int) error {
+	ctx, done := m.observe("MarkFeedRead")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update notification_feed set read_at = ?, updated_at = ? where user_id = ? and read_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), time.Now(), userID)
+	return err
+}
+
+const webhookColumns = `id, url, description, secret, events, active, coalesce(user_id, 0), created_at, updated_at`
+
+// scanWebhook reads a row of webhookColumns into a webhook
+func scanWebhook(row scanner) (models.Webhook, error) {
+	var w models.Webhook
+	var subscribed string
+	err := row.Scan(
+		&w.ID,
+		&w.URL,
+		&w.Description,
+		&w.Secret,
+		&subscribed,
+		&w.Active,
+		&w.UserID,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+	)
+	if subscribed != "" {
+		w.Events = strings.Split(subscribed, ",")
+	}
+	return w, err
+}
+
+// webhooks returns the webhooks matching where
+func (m *mysqlDBRepo) webhooks(ctx context.Context, where string, args ...interface{}) ([]models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var hooks []models.Webhook
+
+	query := `select ` + webhookColumns + ` from webhooks ` + where + ` order by id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return hooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return hooks, err
+		}
+		hooks = append(hooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		return hooks, err
+	}
+
+	return hooks, nil
+}
+
+// AllWebhooks returns every webhook
+func (m *mysqlDBRepo) AllWebhooks() ([]models.Webhook, error) {
+	ctx, done := m.observe("AllWebhooks")
+	defer done()
+
+	return m.webhooks(ctx, "")
+}
+
+// WebhooksForEvent returns the active webhooks subscribed to an event type
+func (m *mysqlDBRepo) WebhooksForEvent(eventType string) ([]models.Webhook, error) {
+	ctx, done := m.observe("WebhooksForEvent")
+	defer done()
+
+	return m.webhooks(ctx, "where active = 1 and find_in_set(?, events) > 0", eventType)
+}
+
+// GetWebhookByID returns a webhook by id
+func (m *mysqlDBRepo) GetWebhookByID(id int) (models.Webhook, error) {
+	ctx, done := m.observe("GetWebhookByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `select ` + webhookColumns + ` from webhooks where id = ?`
+	return scanWebhook(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertWebhook adds a webhook and returns its id
+func (m *mysqlDBRepo) InsertWebhook(w models.Webhook) (int, error) {
+	ctx, done := m.observe("InsertWebhook")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into webhooks (url, description, secret, events, active, created_at, updated_at)
+	values (?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		w.URL,
+		w.Description,
+		w.Secret,
+		strings.Join(w.Events, ","),
+		w.Active,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// UpdateWebhook updates a webhook, its secret included
+func (m *mysqlDBRepo) UpdateWebhook(w models.Webhook) error {
+	ctx, done := m.observe("UpdateWebhook")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update webhooks set url = ?, description = ?, secret = ?, events = ?, active = ?, updated_at = ?
+	where id = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		w.URL,
+		w.Description,
+		w.Secret,
+		strings.Join(w.Events, ","),
+		w.Active,
+		time.Now(),
+		w.ID,
+	)
+	return err
+}
+
+// DeleteWebhook deletes a webhook along with its delivery log
+func (m *mysqlDBRepo) DeleteWebhook(id int) error {
+	ctx, done := m.observe("DeleteWebhook")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from webhooks where id = ?`, id)
+	return err
+}
+
+// InsertWebhookDelivery adds a delivery to the log of its webhook and returns its id
+func (m *mysqlDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	ctx, done := m.observe("InsertWebhookDelivery")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into webhook_deliveries (webhook_id, event_type, payload, status, attempts, next_attempt_at,
+	trace_context, created_at, updated_at)
+	values (?, ?, ?, ?, 0, ?, ?, ?, ?)`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		d.WebhookID,
+		d.EventType,
+		d.Payload,
+		models.WebhookStatusPending,
+		d.NextAttemptAt,
+		traceContext(ctx, d.TraceContext),
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.response_code, COALESCE(d.response_body, ''), COALESCE(d.last_error, ''), d.delivered_at,
+	COALESCE(d.trace_context, ''), d.created_at, d.updated_at, w.id, w.url, w.secret`
+
+// scanWebhookDelivery reads a row of webhookDeliveryColumns into a delivery
+func scanWebhookDelivery(row scanner) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(
+		&d.ID,
+		&d.WebhookID,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.ResponseCode,
+		&d.ResponseBody,
+		&d.LastError,
+		&deliveredAt,
+		&d.TraceContext,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+		&d.Webhook.ID,
+		&d.Webhook.URL,
+		&d.Webhook.Secret,
+	)
+	d.DeliveredAt = deliveredAt.Time
+	return d, err
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries to active webhooks. Deliveries still
+// sending when their lease ran out, because the instance sending them died, are claimed again
+func (m *mysqlDBRepo) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	ctx, done := m.observe("ClaimWebhookDeliveries")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return deliveries, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query := `select ` + webhookDeliveryColumns + `
+	from webhook_deliveries d
+	join webhooks w on (d.webhook_id = w.id)
+	where w.active = 1 and ((d.status = ? and d.next_attempt_at <= ?) or (d.status = ? and d.locked_until < ?))
+	order by d.next_attempt_at asc
+	limit ?
+	for update of d skip locked`
+
+	rows, err := tx.QueryContext(ctx, query, models.WebhookStatusPending, now, models.WebhookStatusSending, now, limit)
+	if err != nil {
+		return deliveries, err
+	}
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			rows.Close()
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return deliveries, err
+	}
+	rows.Close()
+
+	stmt := `update webhook_deliveries set status = ?, locked_until = ?, updated_at = ? where id = ?`
+	for i := range deliveries {
+		_, err = tx.ExecContext(ctx, stmt, models.WebhookStatusSending, now.Add(lease), now, deliveries[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		deliveries[i].Status = models.WebhookStatusSending
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records that a delivery was accepted by its webhook
+func (m *mysqlDBRepo) MarkWebhookDelivered(id, responseCode int, responseBody string) error {
+	ctx, done := m.observe("MarkWebhookDelivered")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `update webhook_deliveries set status = ?, attempts = attempts + 1, response_code = ?, response_body = ?,
+	delivered_at = ?, locked_until = null, last_error = null, updated_at = ? where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, models.WebhookStatusDelivered, responseCode, responseBody, time.Now(), time.Now(), id)
+	return err
+}
+
+// MarkWebhookDeliveryFailed records a failed delivery attempt. The delivery is retried at nextAttempt,
+// or moved to the dead state when dead is true
+func (m *mysqlDBRepo) MarkWebhookDeliveryFailed(id, responseCode int, responseBody, lastError string, nextAttempt time.Time, dead bool) error {
+	ctx, done := m.observe("MarkWebhookDeliveryFailed")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	status := models.WebhookStatusPending
+	if dead {
+		status = models.WebhookStatusDead
+	}
+
+	stmt := `update webhook_deliveries set status = ?, attempts = attempts + 1, next_attempt_at = ?, response_code = ?,
+	response_body = ?, locked_until = null, last_error = ?, updated_at = ? where id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, status, nextAttempt, responseCode, responseBody, lastError, time.Now(), id)
+	return err
+}
+
+// DeliveriesForWebhook returns the latest limit deliveries of a webhook, newest first
+func (m *mysqlDBRepo) DeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error) {
+	ctx, done := m.observe("DeliveriesForWebhook")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	query := `select ` + webhookDeliveryColumns + `
+	from webhook_deliveries d
+	join webhooks w on (d.webhook_id = w.id)
+	where d.webhook_id = ?
+	order by d.created_at desc, d.id desc
+	limit ?`
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, nil
+}
+
+// GetWebhookDeliveryByID returns a delivery by id, with its webhook
+func (m *mysqlDBRepo) GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error) {
+	ctx, done := m.observe("GetWebhookDeliveryByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	query := `select ` + webhookDeliveryColumns + `
+	from webhook_deliveries d
+	join webhooks w on (d.webhook_id = w.id)
+	where d.id = ?`
+	return scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// ReplayWebhookDelivery queues the payload of a delivery to be posted again as a new delivery, so the
+// log keeps the outcome of the original, and returns the id of the new one
+func (m *mysqlDBRepo) ReplayWebhookDelivery(id int) (int, error) {
+	ctx, done := m.observe("ReplayWebhookDelivery")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into webhook_deliveries (webhook_id, event_type, payload, status, attempts, next_attempt_at,
+	created_at, updated_at)
+	select webhook_id, event_type, payload, ?, 0, ?, ?, ? from webhook_deliveries where id = ?`
+
+	result, err := m.DB.ExecContext(ctx, stmt, models.WebhookStatusPending, time.Now(), time.Now(), time.Now(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(newID), nil
+}
@@ -1,10 +1,12 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
@@ -20,6 +22,20 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return 1, nil
 }
 
+// InsertReservationWithMail inserts a reservation, the room restriction holding its dates and
+// the notifications about it, built by mail from the new id, in a single transaction, and returns
+// the new reservation id
+func (m *testDBRepo) InsertReservationWithMail(res models.Reservation, r models.RoomRestriction, mail func(id int) ([]models.MailData, error)) (int, error) {
+	if res.RoomID == 2 || r.RoomID == 1000 {
+		return 0, errors.New("some errors")
+	}
+	_, err := mail(1)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
 // InsertRoomRestriction inserts a room restriction into database
 func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	if r.RoomID == 1000 {
@@ -97,6 +113,19 @@ func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 // GetReservationByID takes reservation by id
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var reservation models.Reservation
+	switch id {
+	case 4:
+		// in the trash
+		reservation.ID = id
+		reservation.DeletedAt = time.Now()
+	case 5:
+		return reservation, sql.ErrNoRows
+	case 6:
+		reservation.ID = id
+		reservation.FirstName = "dung"
+		reservation.LastName = "bui"
+		reservation.Email = "old@example.com"
+	}
 
 	return reservation, nil
 }
@@ -107,12 +136,62 @@ func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
 	return nil
 }
 
-// DeleteReservation deletes a reservation by id from database
+// DeleteReservation moves a reservation to the trash and releases the dates it held
 func (m *testDBRepo) DeleteReservation(id int) error {
+	// reservation 4 is in the trash already
+	if id == 4 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AllDeletedReservations returns a slice of all reservations in the trash
+func (m *testDBRepo) AllDeletedReservations() ([]models.Reservation, error) {
+	reservations := []models.Reservation{
+		{
+			ID:        1,
+			LastName:  "bui",
+			DeletedAt: time.Now(),
+			Room: models.Room{
+				ID:       1,
+				RoomName: "General's Quarters",
+			},
+		},
+	}
+	return reservations, nil
+}
 
+// RestoreReservation takes a reservation out of the trash, holding its dates again
+func (m *testDBRepo) RestoreReservation(id int) error {
+	if id == 2 {
+		return repository.ErrRoomNotAvailable
+	}
+	if id == 3 {
+		return errors.New("some errors")
+	}
 	return nil
 }
 
+// PurgeReservation permanently deletes a reservation in the trash
+func (m *testDBRepo) PurgeReservation(id int) error {
+	if id != 4 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PurgeDeletedReservations permanently deletes reservations moved to the trash before the given time
+func (m *testDBRepo) PurgeDeletedReservations(before time.Time) (int, error) {
+
+	return 0, nil
+}
+
+// NextInviteSequence increments the calendar invite sequence of a reservation and returns it
+func (m *testDBRepo) NextInviteSequence(id int) (int, error) {
+
+	return 1, nil
+}
+
 // UpdateProcessedForReservation updates processed of reservation by id
 func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 
@@ -153,10 +232,10 @@ func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Ti
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// InsertBlockForRoom inserts a room restriction and returns its id
+func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
 
-	return nil
+	return 1, nil
 }
 
 // DeleteBlockByID deletes a room restriction
@@ -164,3 +243,408 @@ func (m *testDBRepo) DeleteBlockByID(id int) error {
 
 	return nil
 }
+
+// InsertAuditLog inserts an audit log entry into database
+func (m *testDBRepo) InsertAuditLog(a models.AuditLog) error {
+
+	return nil
+}
+
+// AllAuditLogs returns audit log entries matching the filter, newest first
+func (m *testDBRepo) AllAuditLogs(f models.AuditFilter) ([]models.AuditLog, error) {
+	logs := []models.AuditLog{
+		{
+			ID:         1,
+			UserID:     1,
+			Action:     "reservation.delete",
+			EntityType: "reservation",
+			EntityID:   1,
+			Before:     `{"FirstName":"dung"}`,
+			Diff:       `{"FirstName":{"from":"dung","to":null}}`,
+			IPAddress:  "127.0.0.1",
+			CreatedAt:  time.Now(),
+			User: models.User{
+				ID:    1,
+				Email: "me@hehe.com",
+			},
+		},
+		{
+			ID:         2,
+			UserID:     1,
+			Action:     "reservation.update",
+			EntityType: "reservation",
+			EntityID:   2,
+			Before:     `{"LastName":"bui"}`,
+			After:      `{"LastName":"=HYPERLINK(\"http://evil.example\")"}`,
+			Diff:       `=HYPERLINK("http://evil.example")`,
+			IPAddress:  "127.0.0.1",
+			CreatedAt:  time.Now(),
+			User: models.User{
+				ID:    1,
+				Email: "me@hehe.com",
+			},
+		},
+	}
+
+	var matched []models.AuditLog
+	for _, x := range logs {
+		if (f.Action == "" || x.Action == f.Action) && (f.EntityType == "" || x.EntityType == f.EntityType) {
+			matched = append(matched, x)
+		}
+	}
+	return matched, nil
+}
+
+// EnqueueMail adds a message to the mail outbox
+func (m *testDBRepo) EnqueueMail(mail models.MailData) error {
+
+	return nil
+}
+
+// ClaimOutboxMail leases up to limit messages that are due for delivery
+func (m *testDBRepo) ClaimOutboxMail(limit int, lease time.Duration) ([]models.OutboxMail, error) {
+	var mail []models.OutboxMail
+
+	return mail, nil
+}
+
+// MarkOutboxMailSent records that a message was delivered
+func (m *testDBRepo) MarkOutboxMailSent(id int) error {
+
+	return nil
+}
+
+// MarkOutboxMailFailed records a failed delivery attempt
+func (m *testDBRepo) MarkOutboxMailFailed(id int, lastError string, nextAttempt time.Time, dead bool) error {
+
+	return nil
+}
+
+// AllOutboxMail returns messages in the mail outbox with the given status
+func (m *testDBRepo) AllOutboxMail(status string) ([]models.OutboxMail, error) {
+	mail := []models.OutboxMail{
+		{
+			ID: 1,
+			Mail: models.MailData{
+				To:      "dung@gmail.com",
+				Subject: "Reservation Confirmation",
+				Content: "<p>Dear dung</p>",
+			},
+			Status:        models.MailStatusDead,
+			Attempts:      8,
+			NextAttemptAt: time.Now(),
+			LastError:     "dial tcp: connection refused",
+			CreatedAt:     time.Now(),
+		},
+	}
+	return mail, nil
+}
+
+// GetOutboxMailByID returns a message from the mail outbox by id
+func (m *testDBRepo) GetOutboxMailByID(id int) (models.OutboxMail, error) {
+	var mail models.OutboxMail
+	if id > 1 {
+		return mail, errors.New("some errors")
+	}
+	mail.ID = id
+	mail.Status = models.MailStatusDead
+	return mail, nil
+}
+
+// ResendOutboxMail queues a message for delivery again with a fresh retry budget
+func (m *testDBRepo) ResendOutboxMail(id int) error {
+
+	return nil
+}
+
+// AllEmailTemplates returns the email templates edited by admins
+func (m *testDBRepo) AllEmailTemplates() ([]models.EmailTemplate, error) {
+	t, _ := m.GetEmailTemplate("owner-notification")
+	return []models.EmailTemplate{t}, nil
+}
+
+// GetEmailTemplate returns the admin edited email template with the given name, or
+// sql.ErrNoRows when the built-in template is in use
+func (m *testDBRepo) GetEmailTemplate(name string) (models.EmailTemplate, error) {
+	var t models.EmailTemplate
+	if name != "owner-notification" {
+		return t, sql.ErrNoRows
+	}
+	t = models.EmailTemplate{
+		ID:        1,
+		Name:      name,
+		Subject:   "New booking from {{.GuestName}}",
+		HTMLBody:  "<p>{{.GuestName}} booked {{.RoomName}}</p>",
+		TextBody:  "{{.GuestName}} booked {{.RoomName}}",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	return t, nil
+}
+
+// UpsertEmailTemplate inserts or replaces the edited version of an email template and returns its id
+func (m *testDBRepo) UpsertEmailTemplate(t models.EmailTemplate) (int, error) {
+
+	return 1, nil
+}
+
+// DeleteEmailTemplate removes the edited version of an email template, so the built-in one is used again
+func (m *testDBRepo) DeleteEmailTemplate(name string) error {
+
+	return nil
+}
+
+// ReservationsArrivingBetween returns the reservations, not in the trash, that start between start and end inclusive
+func (m *testDBRepo) ReservationsArrivingBetween(start, end time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+// ReservationsDepartingBetween returns the reservations, not in the trash, that end between start and end inclusive
+func (m *testDBRepo) ReservationsDepartingBetween(start, end time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+// InsertNotificationWithMail records that the kind message was sent for a reservation and queues it
+// in the mail outbox, in a single transaction. It returns false, queueing nothing, when the message
+// has been sent for the reservation before
+func (m *testDBRepo) InsertNotificationWithMail(reservationID int, kind string, mail models.MailData) (bool, error) {
+
+	return true, nil
+}
+
+// NotificationsForReservation returns the scheduled messages sent for a reservation
+func (m *testDBRepo) NotificationsForReservation(id int) ([]models.Notification, error) {
+	notifications := []models.Notification{
+		{ID: 1, ReservationID: id, Kind: "reminder", CreatedAt: time.Now()},
+	}
+	return notifications, nil
+}
+
+// RegisterRecurringJob adds the name recurring job, due at runAt, unless it exists. When it exists
+// with a different schedule the schedule is changed and the job is due at runAt instead
+func (m *testDBRepo) RegisterRecurringJob(name, schedule string, runAt time.Time) error {
+
+	return nil
+}
+
+// EnqueueJob adds a one-off job and returns its id. A job with the key of an existing one is not
+// added and 0 is returned
+func (m *testDBRepo) EnqueueJob(j models.Job) (int, error) {
+
+	return 1, nil
+}
+
+// ClaimJobs leases up to limit due jobs to owner
+func (m *testDBRepo) ClaimJobs(limit int, lease time.Duration, owner string) ([]models.Job, error) {
+	var jobs []models.Job
+
+	return jobs, nil
+}
+
+// FinishJob records the outcome of a run of j and releases its lease, as long as owner still holds
+// the lease
+func (m *testDBRepo) FinishJob(j models.Job, owner string) error {
+
+	return nil
+}
+
+// AllJobs returns the recurring jobs followed by the one-off jobs, newest first
+func (m *testDBRepo) AllJobs() ([]models.Job, error) {
+	jobs := []models.Job{
+		{ID: 1, Name: "trash-purge", Key: "trash-purge", Schedule: "@hourly", Status: models.JobStatusScheduled, RunAt: time.Now(), LastRunAt: time.Now()},
+		{ID: 2, Name: "scheduled-mail", Key: "scheduled-mail", Schedule: "@hourly", Status: models.JobStatusRunning, RunAt: time.Now(), LockedBy: "web-1"},
+		{ID: 3, Name: "expire-hold", Status: models.JobStatusDead, Attempts: 5, RunAt: time.Now(), LastError: "some errors"},
+	}
+	return jobs, nil
+}
+
+// GetJobByID returns a job by id
+func (m *testDBRepo) GetJobByID(id int) (models.Job, error) {
+	var j models.Job
+	if id > 3 {
+		return j, errors.New("some errors")
+	}
+	j.ID = id
+	j.Name = "trash-purge"
+	j.Status = models.JobStatusScheduled
+	return j, nil
+}
+
+// RunJobNow makes a job that is not running due straight away, with a fresh retry budget
+func (m *testDBRepo) RunJobNow(id int) error {
+
+	return nil
+}
+
+// AllStaff returns the users, who are all staff, with their notification settings
+func (m *testDBRepo) AllStaff() ([]models.User, error) {
+	users := []models.User{
+		{ID: 1, FirstName: "Dung", LastName: "Bui", Email: "dung@gmail.com", AccessLevel: 3, WebhookID: 3, WebhookURL: "http://localhost:8080/hooks"},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@gmail.com", AccessLevel: 1},
+	}
+	return users, nil
+}
+
+// AllNotificationSubscriptions returns the notification subscriptions of all staff
+func (m *testDBRepo) AllNotificationSubscriptions() ([]models.NotificationSubscription, error) {
+	subscriptions := []models.NotificationSubscription{
+		{ID: 1, UserID: 1, EventType: "reservation.created", Channel: models.ChannelEmail},
+		{ID: 2, UserID: 2, EventType: "contact.message", Channel: models.ChannelFeed},
+	}
+	return subscriptions, nil
+}
+
+// SubscriptionsForEvent returns the subscriptions to an event type, with the user to notify
+func (m *testDBRepo) SubscriptionsForEvent(eventType string) ([]models.NotificationSubscription, error) {
+	user := models.User{ID: 1, Email: "dung@gmail.com"}
+	subscriptions := []models.NotificationSubscription{
+		{ID: 1, UserID: 1, EventType: eventType, Channel: models.ChannelEmail, User: user},
+		{ID: 2, UserID: 1, EventType: eventType, Channel: models.ChannelFeed, User: user},
+	}
+	return subscriptions, nil
+}
+
+// UpdateNotificationSettings replaces the webhook address and the subscriptions of a user
+func (m *testDBRepo) UpdateNotificationSettings(userID int, webhookURL string, subscriptions []models.NotificationSubscription) error {
+	if webhookURL == "http://fail.here.com" {
+		return errors.New("some errors")
+	}
+	return nil
+}
+
+// InsertFeedItem adds an entry to a user's notification feed
+func (m *testDBRepo) InsertFeedItem(f models.FeedItem) error {
+
+	return nil
+}
+
+// FeedForUser returns the latest limit entries of a user's notification feed, newest first
+func (m *testDBRepo) FeedForUser(userID, limit int) ([]models.FeedItem, error) {
+	items := []models.FeedItem{
+		{ID: 2, UserID: userID, EventType: "reservation.created", Title: "New booking from John Smith", Body: "General's Quarters, 2050-01-01 to 2050-01-02", Link: "/admin/reservations/all/1/show", CreatedAt: time.Now()},
+		{ID: 1, UserID: userID, EventType: "contact.message", Title: "Message from Ann (ann@here.com)", Body: "Hello", ReadAt: time.Now(), CreatedAt: time.Now()},
+	}
+	return items, nil
+}
+
+// MarkFeedRead marks every entry of a user's notification feed as read
+func (m *testDBRepo) MarkFeedRead(userID int) error {
+
+	return nil
+}
+
+// AllWebhooks returns every webhook
+func (m *testDBRepo) AllWebhooks() ([]models.Webhook, error) {
+	hooks := []models.Webhook{
+		{ID: 1, URL: "https://accounting.here.com/hooks", Description: "Accounting", Secret: "s3cret", Events: []string{"reservation.created", "reservation.cancelled"}, Active: true},
+		{ID: 2, URL: "https://housekeeping.here.com/hooks", Description: "Housekeeping", Secret: "s3cret", Events: []string{"block.created"}, Active: false},
+		{ID: 3, URL: "http://localhost:8080/hooks", Description: "Notifications of dung@gmail.com", Secret: "s3cret", Active: true, UserID: 1},
+	}
+	return hooks, nil
+}
+
+// WebhooksForEvent returns the active webhooks subscribed to an event type
+func (m *testDBRepo) WebhooksForEvent(eventType string) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+
+	return hooks, nil
+}
+
+// GetWebhookByID returns a webhook by id
+func (m *testDBRepo) GetWebhookByID(id int) (models.Webhook, error) {
+	var w models.Webhook
+	if id > 3 {
+		return w, errors.New("some errors")
+	}
+	w.ID = id
+	w.URL = "https://accounting.here.com/hooks"
+	w.Secret = "s3cret"
+	w.Events = []string{"reservation.created"}
+	w.Active = true
+	if id == 3 {
+		// the webhook of a staff member
+		w.URL = "http://localhost:8080/hooks"
+		w.Events = nil
+		w.UserID = 1
+	}
+	return w, nil
+}
+
+// InsertWebhook adds a webhook and returns its id
+func (m *testDBRepo) InsertWebhook(w models.Webhook) (int, error) {
+
+	return 1, nil
+}
+
+// UpdateWebhook updates a webhook, its secret included
+func (m *testDBRepo) UpdateWebhook(w models.Webhook) error {
+	if w.ID == 2 {
+		return errors.New("some errors")
+	}
+	return nil
+}
+
+// DeleteWebhook deletes a webhook along with its delivery log
+func (m *testDBRepo) DeleteWebhook(id int) error {
+
+	return nil
+}
+
+// InsertWebhookDelivery adds a delivery to the log of its webhook and returns its id
+func (m *testDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+
+	return 1, nil
+}
+
+// ClaimWebhookDeliveries leases up to limit due deliveries to active webhooks
+func (m *testDBRepo) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records that a delivery was accepted by its webhook
+func (m *testDBRepo) MarkWebhookDelivered(id, responseCode int, responseBody string) error {
+
+	return nil
+}
+
+// MarkWebhookDeliveryFailed records a failed delivery attempt. The delivery is retried at nextAttempt,
+// or moved to the dead state when dead is true
+func (m *testDBRepo) MarkWebhookDeliveryFailed(id, responseCode int, responseBody, lastError string, nextAttempt time.Time, dead bool) error {
+
+	return nil
+}
+
+// DeliveriesForWebhook returns the latest limit deliveries of a webhook, newest first
+func (m *testDBRepo) DeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error) {
+	deliveries := []models.WebhookDelivery{
+		{ID: 2, WebhookID: webhookID, EventType: "reservation.cancelled", Payload: `{"type":"reservation.cancelled"}`, Status: models.WebhookStatusDead, Attempts: 8, ResponseCode: 500, ResponseBody: "oops", LastError: "webhook answered 500 Internal Server Error", CreatedAt: time.Now()},
+		{ID: 1, WebhookID: webhookID, EventType: "reservation.created", Payload: `{"type":"reservation.created"}`, Status: models.WebhookStatusDelivered, Attempts: 1, ResponseCode: 200, DeliveredAt: time.Now(), CreatedAt: time.Now()},
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDeliveryByID returns a delivery by id, with its webhook
+func (m *testDBRepo) GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	if id > 2 {
+		return d, errors.New("some errors")
+	}
+	d.ID = id
+	d.WebhookID = 1
+	d.EventType = "reservation.created"
+	d.Status = models.WebhookStatusDead
+	return d, nil
+}
+
+// ReplayWebhookDelivery queues the payload of a delivery to be posted again as a new delivery, so the
+// log keeps the outcome of the original, and returns the id of the new one
+func (m *testDBRepo) ReplayWebhookDelivery(id int) (int, error) {
+
+	return 3, nil
+}
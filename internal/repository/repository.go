@@ -1,15 +1,24 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/DungBuiTien1999/bookings/internal/models"
 )
 
+// ErrRoomNotAvailable is returned when a reservation can't be placed because its room is already taken
+var ErrRoomNotAvailable = errors.New("room is not available for those dates")
+
 type DatabaseRepo interface {
+	// WithContext returns the repository tracing its calls as part of the request of ctx
+	WithContext(ctx context.Context) DatabaseRepo
+
 	AllUsers() bool
 
 	InsertReservation(res models.Reservation) (int, error)
+	InsertReservationWithMail(res models.Reservation, r models.RoomRestriction, mail func(id int) ([]models.MailData, error)) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
@@ -25,8 +34,64 @@ type DatabaseRepo interface {
 	GetReservationByID(id int) (models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
 	DeleteReservation(id int) error
+	AllDeletedReservations() ([]models.Reservation, error)
+	RestoreReservation(id int) error
+	PurgeReservation(id int) error
+	PurgeDeletedReservations(before time.Time) (int, error)
+	NextInviteSequence(id int) (int, error)
 	UpdateProcessedForReservation(id, processed int) error
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
+	InsertBlockForRoom(id int, startDate time.Time) (int, error)
 	DeleteBlockByID(id int) error
+
+	EnqueueMail(m models.MailData) error
+	ClaimOutboxMail(limit int, lease time.Duration) ([]models.OutboxMail, error)
+	MarkOutboxMailSent(id int) error
+	MarkOutboxMailFailed(id int, lastError string, nextAttempt time.Time, dead bool) error
+	AllOutboxMail(status string) ([]models.OutboxMail, error)
+	GetOutboxMailByID(id int) (models.OutboxMail, error)
+	ResendOutboxMail(id int) error
+
+	InsertAuditLog(a models.AuditLog) error
+	AllAuditLogs(f models.AuditFilter) ([]models.AuditLog, error)
+
+	ReservationsArrivingBetween(start, end time.Time) ([]models.Reservation, error)
+	ReservationsDepartingBetween(start, end time.Time) ([]models.Reservation, error)
+	InsertNotificationWithMail(reservationID int, kind string, mail models.MailData) (bool, error)
+	NotificationsForReservation(id int) ([]models.Notification, error)
+
+	RegisterRecurringJob(name, schedule string, runAt time.Time) error
+	EnqueueJob(j models.Job) (int, error)
+	ClaimJobs(limit int, lease time.Duration, owner string) ([]models.Job, error)
+	FinishJob(j models.Job, owner string) error
+	AllJobs() ([]models.Job, error)
+	GetJobByID(id int) (models.Job, error)
+	RunJobNow(id int) error
+
+	AllStaff() ([]models.User, error)
+	AllNotificationSubscriptions() ([]models.NotificationSubscription, error)
+	SubscriptionsForEvent(eventType string) ([]models.NotificationSubscription, error)
+	UpdateNotificationSettings(userID int, webhookURL string, subscriptions []models.NotificationSubscription) error
+	InsertFeedItem(f models.FeedItem) error
+	FeedForUser(userID, limit int) ([]models.FeedItem, error)
+	MarkFeedRead(userID int) error
+
+	AllWebhooks() ([]models.Webhook, error)
+	WebhooksForEvent(eventType string) ([]models.Webhook, error)
+	GetWebhookByID(id int) (models.Webhook, error)
+	InsertWebhook(w models.Webhook) (int, error)
+	UpdateWebhook(w models.Webhook) error
+	DeleteWebhook(id int) error
+	InsertWebhookDelivery(d models.WebhookDelivery) (int, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	MarkWebhookDelivered(id, responseCode int, responseBody string) error
+	MarkWebhookDeliveryFailed(id, responseCode int, responseBody, lastError string, nextAttempt time.Time, dead bool) error
+	DeliveriesForWebhook(webhookID, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error)
+	ReplayWebhookDelivery(id int) (int, error)
+
+	AllEmailTemplates() ([]models.EmailTemplate, error)
+	GetEmailTemplate(name string) (models.EmailTemplate, error)
+	UpsertEmailTemplate(t models.EmailTemplate) (int, error)
+	DeleteEmailTemplate(name string) error
 }
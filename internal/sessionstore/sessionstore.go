@@ -0,0 +1,99 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MySQLStore keeps the sessions of scs in the sessions table, so they survive restarts and can be
+// shared by several instances
+type MySQLStore struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewMySQL returns a store for the sessions table of db, removing the expired rows every cleanup.
+// A cleanup of zero leaves them in place
+func NewMySQL(db *sql.DB, cleanup time.Duration, logger *slog.Logger) *MySQLStore {
+	s := &MySQLStore{
+		db:     db,
+		logger: logger,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cleanup > 0 {
+		go s.cleanup(cleanup)
+	} else {
+		close(s.done)
+	}
+	return s
+}
+
+// Find returns the data of the session token, or false when there is none or it expired
+func (s *MySQLStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	err := s.db.QueryRow(`select data from sessions where token = ? and utc_timestamp(6) < expiry`, token).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit stores the data of the session token until expiry, replacing what it held
+func (s *MySQLStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := s.db.Exec(`insert into sessions (token, data, expiry) values (?, ?, ?)
+		on duplicate key update data = values(data), expiry = values(expiry)`, token, b, expiry.UTC())
+	return err
+}
+
+// Delete removes the session token
+func (s *MySQLStore) Delete(token string) error {
+	_, err := s.db.Exec(`delete from sessions where token = ?`, token)
+	return err
+}
+
+// Count returns how many sessions have not expired
+func (s *MySQLStore) Count() (int, error) {
+	var n int
+	err := s.db.QueryRow(`select count(*) from sessions where utc_timestamp(6) < expiry`).Scan(&n)
+	return n, err
+}
+
+// StopCleanup stops removing the expired rows
+func (s *MySQLStore) StopCleanup() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// cleanup removes the expired rows every interval until StopCleanup is called
+func (s *MySQLStore) cleanup(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			res, err := s.db.Exec(`delete from sessions where expiry < utc_timestamp(6)`)
+			if err != nil {
+				s.logger.Error("can't remove expired sessions", "error", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				s.logger.Debug("removed expired sessions", "count", n)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
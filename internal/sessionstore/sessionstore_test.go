@@ -0,0 +1,243 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTable is the sessions table behind a fake connection, answering the statements of the store
+type fakeTable struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	data   []byte
+	expiry time.Time
+}
+
+func (t *fakeTable) put(token string, data []byte, expiry time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows[token] = fakeRow{data, expiry}
+}
+
+func (t *fakeTable) has(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.rows[token]
+	return ok
+}
+
+func (t *fakeTable) exec(query string, args []driver.Value) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "insert into sessions"):
+		t.rows[args[0].(string)] = fakeRow{args[1].([]byte), args[2].(time.Time)}
+		return 1, nil
+	case strings.HasPrefix(query, "delete from sessions where token = ?"):
+		_, ok := t.rows[args[0].(string)]
+		delete(t.rows, args[0].(string))
+		if ok {
+			return 1, nil
+		}
+		return 0, nil
+	case strings.HasPrefix(query, "delete from sessions where expiry < utc_timestamp(6)"):
+		var n int64
+		for token, r := range t.rows {
+			if r.expiry.Before(time.Now()) {
+				delete(t.rows, token)
+				n++
+			}
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("unexpected statement %q", query)
+}
+
+func (t *fakeTable) query(query string, args []driver.Value) (*fakeRows, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "select data from sessions where token = ? and utc_timestamp(6) < expiry"):
+		r, ok := t.rows[args[0].(string)]
+		if !ok || !time.Now().Before(r.expiry) {
+			return &fakeRows{column: "data"}, nil
+		}
+		return &fakeRows{column: "data", values: []driver.Value{r.data}}, nil
+	case strings.HasPrefix(query, "select count(*) from sessions where utc_timestamp(6) < expiry"):
+		var n int64
+		for _, r := range t.rows {
+			if time.Now().Before(r.expiry) {
+				n++
+			}
+		}
+		return &fakeRows{column: "count(*)", values: []driver.Value{n}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", query)
+}
+
+// fakeConnector opens connections to the same table
+type fakeConnector struct{ table *fakeTable }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ table *fakeTable }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.table, strings.Join(strings.Fields(query), " ")}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, fmt.Errorf("no transactions") }
+
+type fakeStmt struct {
+	table *fakeTable
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	n, err := s.table.exec(s.query, args)
+	return driver.RowsAffected(n), err
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.table.query(s.query, args)
+}
+
+// fakeRows holds at most one row of one column
+type fakeRows struct {
+	column string
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{r.column} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func newTestStore(t *testing.T, cleanup time.Duration) (*MySQLStore, *fakeTable) {
+	table := &fakeTable{rows: make(map[string]fakeRow)}
+	db := sql.OpenDB(fakeConnector{table})
+	t.Cleanup(func() { _ = db.Close() })
+	return NewMySQL(db, cleanup, slog.New(slog.NewTextHandler(io.Discard, nil))), table
+}
+
+func TestFind(t *testing.T) {
+	s, table := newTestStore(t, 0)
+	defer s.StopCleanup()
+
+	table.put("live", []byte("data"), time.Now().Add(time.Hour))
+	table.put("expired", []byte("old"), time.Now().Add(-time.Second))
+
+	b, found, err := s.Find("live")
+	if err != nil || !found || string(b) != "data" {
+		t.Errorf("expected the live session, got %q %v %v", b, found, err)
+	}
+
+	for _, token := range []string{"expired", "missing"} {
+		b, found, err = s.Find(token)
+		if err != nil || found || b != nil {
+			t.Errorf("expected no %s session, got %q %v %v", token, b, found, err)
+		}
+	}
+}
+
+func TestCommit(t *testing.T) {
+	s, _ := newTestStore(t, 0)
+	defer s.StopCleanup()
+
+	err := s.Commit("a", []byte("first"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Commit("a", []byte("second"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := s.Find("a")
+	if err != nil || !found || string(b) != "second" {
+		t.Errorf("expected the session to be replaced, got %q %v %v", b, found, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s, table := newTestStore(t, 0)
+	defer s.StopCleanup()
+
+	table.put("a", []byte("data"), time.Now().Add(time.Hour))
+	err := s.Delete("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.has("a") {
+		t.Error("expected the session to be deleted")
+	}
+}
+
+func TestCount(t *testing.T) {
+	s, table := newTestStore(t, 0)
+	defer s.StopCleanup()
+
+	table.put("a", []byte("x"), time.Now().Add(time.Hour))
+	table.put("b", []byte("x"), time.Now().Add(time.Hour))
+	table.put("c", []byte("x"), time.Now().Add(-time.Second))
+
+	n, err := s.Count()
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 active sessions, got %d %v", n, err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	s, table := newTestStore(t, 5*time.Millisecond)
+
+	table.put("live", []byte("x"), time.Now().Add(time.Hour))
+	table.put("expired", []byte("x"), time.Now().Add(-time.Second))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for table.has("expired") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if table.has("expired") || !table.has("live") {
+		t.Fatal("expected the cleanup to remove only the expired session")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.StopCleanup()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected StopCleanup to end the cleanup")
+	}
+
+	table.put("expired", []byte("x"), time.Now().Add(-time.Second))
+	time.Sleep(20 * time.Millisecond)
+	if !table.has("expired") {
+		t.Error("expected no cleanup after StopCleanup")
+	}
+}
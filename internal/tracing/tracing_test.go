@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSetup(t *testing.T) {
+	if _, err := Setup("jaeger", "", nil); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+
+	stop, err := Setup("none", "", nil)
+	if err != nil || stop(context.Background()) != nil {
+		t.Errorf("expected the none exporter to set up and stop, got %v", err)
+	}
+
+	var out bytes.Buffer
+	stop, err = Setup("stdout", "", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Start(context.Background(), "db.AllRooms")
+	span.End()
+	if err := stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Name":"db.AllRooms"`) || !strings.Contains(out.String(), `"Value":"bookings"`) {
+		t.Errorf("expected the span with the service name, got %s", out.String())
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	_, _ = Setup("none", "", nil)
+
+	// the trace started by a proxy is carried on by the request span
+	req := httptest.NewRequest("GET", "/admin/reservations/all/7/show", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, span := StartRequest(req)
+
+	// work queued by the request runs in its trace
+	traceparent := Inject(ctx)
+	if !strings.Contains(traceparent, "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Fatalf("expected the trace of the proxy, got %q", traceparent)
+	}
+	_, job := Start(Extract(context.Background(), traceparent), "mail.send")
+	End(job, errors.New("connection refused"))
+
+	EndRequest(span, "GET", "/admin/reservations/{src}/{id}/show", 500)
+
+	if Extract(context.Background(), "") != context.Background() {
+		t.Error("expected an empty traceparent to leave the context alone")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	mail, request := spans[0], spans[1]
+	if request.Name() != "GET /admin/reservations/{src}/{id}/show" || request.Status().Code != codes.Error {
+		t.Errorf("unexpected request span %s, %v", request.Name(), request.Status())
+	}
+	if mail.Parent().SpanID() != request.SpanContext().SpanID() || mail.SpanContext().TraceID() != request.SpanContext().TraceID() {
+		t.Error("expected the mail span to be a child of the request span")
+	}
+	if mail.Status().Code != codes.Error || len(mail.Events()) != 1 {
+		t.Errorf("expected the mail span to record its error, got %v", mail.Status())
+	}
+}
@@ -0,0 +1,219 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/events"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+	"github.com/DungBuiTien1999/bookings/internal/tracing"
+	"github.com/DungBuiTien1999/bookings/internal/worker"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// headers sent with every delivery. The signature is "sha256=" followed by the hex HMAC-SHA256, keyed
+// with the webhook secret, of the timestamp, a dot and the body, so receivers can reject replays of
+// old deliveries as well as forged ones
+const (
+	EventHeader     = "X-Bookings-Event"
+	DeliveryHeader  = "X-Bookings-Delivery"
+	TimestampHeader = "X-Bookings-Timestamp"
+	SignatureHeader = "X-Bookings-Signature"
+)
+
+// maxResponseBody is how much of a receiver's response is kept in the delivery log
+const maxResponseBody = 4096
+
+// Sign returns the signature of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at timestamp
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// NewSecret returns a random secret to sign deliveries with
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Store is the part of the database repository the dispatcher needs
+type Store interface {
+	WebhooksForEvent(eventType string) ([]models.Webhook, error)
+	InsertWebhookDelivery(d models.WebhookDelivery) (int, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	MarkWebhookDelivered(id, responseCode int, responseBody string) error
+	MarkWebhookDeliveryFailed(id, responseCode int, responseBody, lastError string, nextAttempt time.Time, dead bool) error
+}
+
+// Dispatcher records events in the delivery log of the webhooks subscribed to them and posts them
+// with a pool of workers, retrying failures with exponential backoff until MaxAttempts is reached
+// and the delivery becomes dead
+type Dispatcher struct {
+	Store        Store
+	Client       *http.Client
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Logger       *slog.Logger
+
+	pool *worker.Pool[models.WebhookDelivery]
+}
+
+// New returns a dispatcher with default settings, posting with a 10 second timeout
+func New(store Store, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		Workers:      2,
+		PollInterval: 5 * time.Second,
+		Lease:        2 * time.Minute,
+		MaxAttempts:  8,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   6 * time.Hour,
+		Logger:       logger,
+	}
+}
+
+// Enqueue is the events.Subscriber adding e to the delivery log of every active webhook subscribed to it
+func (d *Dispatcher) Enqueue(ctx context.Context, e events.Event) error {
+	hooks, err := d.Store.WebhooksForEvent(e.Type)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	for _, h := range hooks {
+		err := d.Send(ctx, e, h.ID)
+		if err != nil {
+			d.Logger.Error("can't queue webhook delivery", "event", e.Type, "webhook_id", h.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// Send adds e to the delivery log of the webhook hook, whatever events it is subscribed to. It is how
+// the notifier posts to the webhooks of staff members
+func (d *Dispatcher) Send(ctx context.Context, e events.Event, hook int) error {
+	body, err := e.JSON()
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Store.InsertWebhookDelivery(models.WebhookDelivery{
+		WebhookID:     hook,
+		EventType:     e.Type,
+		Payload:       string(body),
+		Status:        models.WebhookStatusPending,
+		NextAttemptAt: time.Now(),
+		TraceContext:  tracing.Inject(ctx),
+	})
+	return err
+}
+
+// Start starts the poller and the workers
+func (d *Dispatcher) Start() {
+	d.pool = &worker.Pool[models.WebhookDelivery]{
+		Workers:      d.Workers,
+		PollInterval: d.PollInterval,
+		Claim: func() ([]models.WebhookDelivery, error) {
+			return d.Store.ClaimWebhookDeliveries(d.Workers*5, d.Lease)
+		},
+		Work:   d.deliver,
+		Logger: d.Logger.With("queue", "webhooks"),
+	}
+	d.pool.Start()
+}
+
+// Stop stops polling and waits for the workers to finish the deliveries they hold
+func (d *Dispatcher) Stop() {
+	d.pool.Stop()
+}
+
+// deliver posts one delivery and records the outcome, in the trace of the request that published the event
+func (d *Dispatcher) deliver(x models.WebhookDelivery) {
+	_, span := tracing.Start(tracing.Extract(context.Background(), x.TraceContext), "webhook.post",
+		attribute.Int("webhook.id", x.Webhook.ID),
+		attribute.Int("webhook.delivery_id", x.ID),
+		attribute.String("webhook.event", x.EventType),
+	)
+	code, body, err := d.Post(x)
+	span.SetAttributes(attribute.Int("http.response.status_code", code))
+	tracing.End(span, err)
+	if err == nil {
+		err = d.Store.MarkWebhookDelivered(x.ID, code, body)
+		if err != nil {
+			d.Logger.Error("can't mark webhook delivery delivered", "delivery_id", x.ID, "error", err)
+			return
+		}
+		d.Logger.Info("webhook delivery posted", "delivery_id", x.ID, "webhook_id", x.Webhook.ID, "event", x.EventType,
+			"url", x.Webhook.URL, "attempt", x.Attempts+1, "status", code)
+		return
+	}
+
+	attempts := x.Attempts + 1
+	dead := attempts >= d.MaxAttempts
+	d.Logger.Error("webhook delivery failed", "delivery_id", x.ID, "webhook_id", x.Webhook.ID, "event", x.EventType,
+		"url", x.Webhook.URL, "attempt", attempts, "status", code, "dead", dead, "error", err)
+
+	err = d.Store.MarkWebhookDeliveryFailed(x.ID, code, body, err.Error(), time.Now().Add(worker.Backoff(d.BaseBackoff, d.MaxBackoff, attempts)), dead)
+	if err != nil {
+		d.Logger.Error("can't mark webhook delivery failed", "delivery_id", x.ID, "error", err)
+	}
+}
+
+// Post signs and posts x to its webhook, returning the response status code and the start of the
+// response body. A response other than 2xx is an error
+func (d *Dispatcher) Post(x models.WebhookDelivery) (int, string, error) {
+	body := []byte(x.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, x.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Bookings-Webhook/1.0")
+	req.Header.Set(EventHeader, x.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(x.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(x.Webhook.Secret, timestamp, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(b), fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, string(b), nil
+}
@@ -0,0 +1,207 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DungBuiTien1999/bookings/internal/events"
+	"github.com/DungBuiTien1999/bookings/internal/models"
+)
+
+type fakeStore struct {
+	mu         sync.Mutex
+	hooks      []models.Webhook
+	due        []models.WebhookDelivery
+	inserted   []models.WebhookDelivery
+	delivered  map[int]int
+	failed     map[int]bool
+	failedCode map[int]int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		delivered:  make(map[int]int),
+		failed:     make(map[int]bool),
+		failedCode: make(map[int]int),
+	}
+}
+
+func (s *fakeStore) WebhooksForEvent(eventType string) ([]models.Webhook, error) {
+	var found []models.Webhook
+	for _, h := range s.hooks {
+		for _, e := range h.Events {
+			if e == eventType && h.Active {
+				found = append(found, h)
+			}
+		}
+	}
+	return found, nil
+}
+
+func (s *fakeStore) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inserted = append(s.inserted, d)
+	return len(s.inserted), nil
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := s.due
+	s.due = nil
+	return due, nil
+}
+
+func (s *fakeStore) MarkWebhookDelivered(id, responseCode int, responseBody string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[id] = responseCode
+	return nil
+}
+
+func (s *fakeStore) MarkWebhookDeliveryFailed(id, responseCode int, responseBody, lastError string, nextAttempt time.Time, dead bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[id] = dead
+	s.failedCode[id] = responseCode
+	return nil
+}
+
+func (s *fakeStore) done() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.delivered) + len(s.failed)
+}
+
+func newTestDispatcher(store Store) *Dispatcher {
+	discard := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d := New(store, discard)
+	d.PollInterval = 10 * time.Millisecond
+	return d
+}
+
+// receiver is a local webhook endpoint checking signatures, failing on /broken
+func receiver(t *testing.T, secret string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var received []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if !Verify(secret, timestamp, body, r.Header.Get(SignatureHeader)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/broken" {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, r.Header.Get(EventHeader)+" "+r.Header.Get(DeliveryHeader))
+		mu.Unlock()
+		_, _ = w.Write([]byte("thanks"))
+	}))
+	return srv, &received
+}
+
+func TestEnqueue(t *testing.T) {
+	store := newFakeStore()
+	store.hooks = []models.Webhook{
+		{ID: 1, Events: []string{events.ReservationCreated, events.BlockCreated}, Active: true},
+		{ID: 2, Events: []string{events.BlockCreated}, Active: true},
+		{ID: 3, Events: []string{events.ReservationCreated}, Active: false},
+	}
+	d := newTestDispatcher(store)
+
+	err := d.Enqueue(context.Background(), events.Event{Type: events.ReservationCreated, Reservation: models.Reservation{ID: 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.inserted) != 1 || store.inserted[0].WebhookID != 1 || store.inserted[0].Status != models.WebhookStatusPending {
+		t.Fatalf("expected one pending delivery to webhook 1, got %+v", store.inserted)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(store.inserted[0].Payload), &payload); err != nil || payload["type"] != events.ReservationCreated {
+		t.Errorf("unexpected payload %s", store.inserted[0].Payload)
+	}
+
+	_ = d.Enqueue(context.Background(), events.Event{Type: events.BlockCreated})
+	if len(store.inserted) != 3 {
+		t.Errorf("expected a delivery to each of webhooks 1 and 2, got %+v", store.inserted[1:])
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	srv, received := receiver(t, "s3cret")
+	defer srv.Close()
+
+	hook := models.Webhook{ID: 1, URL: srv.URL + "/hook", Secret: "s3cret"}
+	broken := models.Webhook{ID: 2, URL: srv.URL + "/broken", Secret: "s3cret"}
+	forged := models.Webhook{ID: 3, URL: srv.URL + "/hook", Secret: "not the secret"}
+
+	store := newFakeStore()
+	store.due = []models.WebhookDelivery{
+		{ID: 1, EventType: events.ReservationCreated, Payload: `{"type":"reservation.created"}`, Webhook: hook},
+		{ID: 2, EventType: events.BlockDeleted, Payload: `{"type":"block.deleted"}`, Webhook: broken},
+		{ID: 3, EventType: events.BlockDeleted, Payload: `{"type":"block.deleted"}`, Webhook: broken, Attempts: 7},
+		{ID: 4, EventType: events.BlockDeleted, Payload: `{"type":"block.deleted"}`, Webhook: forged},
+	}
+
+	d := newTestDispatcher(store)
+	d.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && store.done() < 4 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Stop()
+
+	if store.delivered[1] != http.StatusOK || len(*received) != 1 || (*received)[0] != "reservation.created 1" {
+		t.Errorf("expected delivery 1 to be posted, got %v and %v", store.delivered, *received)
+	}
+	if dead, ok := store.failed[2]; !ok || dead || store.failedCode[2] != http.StatusServiceUnavailable {
+		t.Errorf("expected delivery 2 to be retried, got %v", store.failed)
+	}
+	if !store.failed[3] {
+		t.Error("expected delivery 3 to be dead after its last attempt")
+	}
+	if store.failedCode[4] != http.StatusUnauthorized {
+		t.Errorf("expected the receiver to reject a delivery signed with the wrong secret, got %d", store.failedCode[4])
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"reservation.created"}`)
+	sig := Sign("s3cret", 1700000000, body)
+
+	if len(sig) != len("sha256=")+64 || sig[:7] != "sha256=" {
+		t.Errorf("unexpected signature format %q", sig)
+	}
+	if !Verify("s3cret", 1700000000, body, sig) {
+		t.Error("expected the signature to verify")
+	}
+	if Verify("s3cret", 1700000001, body, sig) {
+		t.Error("expected a different timestamp to fail verification")
+	}
+	if Verify("s3cret", 1700000000, []byte(`{}`), sig) {
+		t.Error("expected a different body to fail verification")
+	}
+
+	a, _ := NewSecret()
+	b, _ := NewSecret()
+	if len(a) != 64 || a == b {
+		t.Errorf("expected distinct 32 byte secrets, got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Pool claims due items from a queue kept in the database and works them with a pool of workers.
+// It is the loop shared by the mail outbox, the background jobs and the webhook deliveries: items
+// are leased by Claim, so items claimed but never worked, because the pool stopped or the instance
+// died, are claimed again once their lease runs out
+type Pool[T any] struct {
+	Workers      int
+	PollInterval time.Duration
+	// Claim leases the items that are due
+	Claim func() ([]T, error)
+	// Work works one item and records its outcome
+	Work   func(T)
+	Logger *slog.Logger
+
+	queue chan T
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Start starts the poller and the workers
+func (p *Pool[T]) Start() {
+	p.queue = make(chan T)
+	p.quit = make(chan struct{})
+
+	for i := 0; i < p.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	p.wg.Add(1)
+	go p.poll()
+}
+
+// Close stops claiming items. The workers finish the items they hold, Wait waits for them
+func (p *Pool[T]) Close() {
+	close(p.quit)
+}
+
+// Wait waits for the poller and the workers to return after Close
+func (p *Pool[T]) Wait() {
+	p.wg.Wait()
+}
+
+// Stop stops claiming items and waits for the workers to finish the items they hold
+func (p *Pool[T]) Stop() {
+	p.Close()
+	p.Wait()
+}
+
+// poll claims due items and hands them to the workers
+func (p *Pool[T]) poll() {
+	defer p.wg.Done()
+	defer close(p.queue)
+
+	ticker := time.NewTicker(p.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		items, err := p.Claim()
+		if err != nil {
+			p.Logger.Error("can't claim due items", "error", err)
+		}
+
+		for _, x := range items {
+			select {
+			case p.queue <- x:
+			case <-p.quit:
+				// claimed items that did not start are picked up again once their lease runs out
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// work works items from the queue until it is closed
+func (p *Pool[T]) work() {
+	defer p.wg.Done()
+
+	for x := range p.queue {
+		p.Work(x)
+	}
+}
+
+// Backoff returns how long to wait before retrying after the given number of failed attempts: base
+// after the first, doubling with each one after it up to max
+func Backoff(base, max time.Duration, attempts int) time.Duration {
+	wait := base
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	return wait
+}
@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	var mu sync.Mutex
+	due := []int{1, 2, 3}
+	var worked []int
+	claims := 0
+
+	p := &Pool[int]{
+		Workers:      2,
+		PollInterval: 10 * time.Millisecond,
+		Claim: func() ([]int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			claims++
+			if claims == 1 {
+				return nil, errors.New("database unavailable")
+			}
+			items := due
+			due = nil
+			return items, nil
+		},
+		Work: func(x int) {
+			mu.Lock()
+			defer mu.Unlock()
+			worked = append(worked, x)
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	p.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(worked)
+		mu.Unlock()
+		if n == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Stop()
+
+	if len(worked) != 3 {
+		t.Errorf("expected the 3 items claimed after the failed claim to be worked, got %v", worked)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{20, 10 * time.Minute},
+	}
+
+	for _, e := range tests {
+		if got := Backoff(time.Minute, 10*time.Minute, e.attempts); got != e.expected {
+			t.Errorf("backoff after %d attempts: expected %s, got %s", e.attempts, e.expected, got)
+		}
+	}
+}
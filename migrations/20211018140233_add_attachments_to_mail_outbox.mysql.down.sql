@@ -0,0 +1 @@
+ALTER TABLE `mail_outbox` DROP COLUMN `attachments`;
\ No newline at end of file
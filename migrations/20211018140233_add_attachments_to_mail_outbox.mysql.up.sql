@@ -0,0 +1 @@
+ALTER TABLE `mail_outbox` ADD COLUMN `attachments` MEDIUMTEXT NULL;
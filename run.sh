@@ -1,4 +1,4 @@
 #!/bin/bash
 
 go build -o bookings cmd/web/*.go
-./bookings -dbname=golangbookings -dbuser=root -dbpass=root -dbport=3306 -cache=false -production=false
\ No newline at end of file
+./bookings -dbname=golangbookings -dbuser=root -dbpass=root -dbport=3306 -filesdir=. -reload -production=false -sessionstore=mysql
\ No newline at end of file